@@ -0,0 +1,20 @@
+package main
+
+import "time"
+
+// Clock is what time-dependent logic asks for time, so it can run on
+// FakeClock in tests instead of the wall clock
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// FakeClock is a Clock which only moves when Advance is called
+type FakeClock struct {
+	now     time.Time
+	waiters []fakeClockWaiter
+	mutex   sync.Mutex
+}
+
+type fakeClockWaiter struct {
+	deadline time.Time
+	channel  chan time.Time
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (clock *FakeClock) Now() time.Time {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	return clock.now
+}
+
+func (clock *FakeClock) After(d time.Duration) <-chan time.Time {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	channel := make(chan time.Time, 1)
+	if d <= 0 {
+		channel <- clock.now
+		return channel
+	}
+	clock.waiters = append(clock.waiters, fakeClockWaiter{clock.now.Add(d), channel})
+	return channel
+}
+
+// Advance moves clock forward and fires every After whose time has come
+func (clock *FakeClock) Advance(d time.Duration) {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	clock.now = clock.now.Add(d)
+	sort.Slice(clock.waiters, func(i, j int) bool {
+		return clock.waiters[i].deadline.Before(clock.waiters[j].deadline)
+	})
+	waiters := clock.waiters[:0]
+	for _, waiter := range clock.waiters {
+		if waiter.deadline.After(clock.now) {
+			waiters = append(waiters, waiter)
+			continue
+		}
+		waiter.channel <- clock.now
+	}
+	clock.waiters = waiters
+}
+
+// BlockUntilWaiters waits until count Afters are pending, so Advance isn't
+// called before the code under test started waiting
+func (clock *FakeClock) BlockUntilWaiters(count int) {
+	for {
+		clock.mutex.Lock()
+		waitersCount := len(clock.waiters)
+		clock.mutex.Unlock()
+		if waitersCount >= count {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
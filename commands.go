@@ -0,0 +1,534 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"./telegram"
+)
+
+// callbackCodec encodes data of inline buttons handled by Spotter's router,
+// it's set in main when the secret is known
+var callbackCodec *telegram.CallbackCodec
+
+// callbackSecret derives secret callback data is signed with from bot token,
+// so buttons keep working after restart and nobody but the bot can sign them
+func callbackSecret(telegramToken string) []byte {
+	mac := hmac.New(sha256.New, []byte(telegramToken))
+	mac.Write([]byte("callback data"))
+	return mac.Sum(nil)
+}
+
+// repeatAction adds spotted target to tracing list again
+type repeatAction struct {
+	Id              int  `json:"i"`
+	DomainIsPrimary bool `json:"d,omitempty"`
+}
+
+func (repeatAction) CallbackName() string {
+	return "repeat"
+}
+
+// noopAction is data of buttons which are only labels
+type noopAction struct{}
+
+func (noopAction) CallbackName() string {
+	return "noop"
+}
+
+// conversationTimeout is how long the bot waits for answers to its questions
+const conversationTimeout = time.Minute * 5
+
+// Texts of reply keyboard buttons
+const (
+	addButtonText        = "➕ Add"
+	listButtonText       = "📝 List"
+	quietHoursButtonText = "🌙 Quiet hours"
+	clearButtonText      = "♻️ Clear List"
+	yesButtonText        = "✅ Yes"
+	noButtonText         = "❌ No"
+)
+
+func mainKeyboard() *telegram.ReplyMarkup {
+	return &telegram.ReplyMarkup{
+		ReplyKeyboardMarkup: &telegram.ReplyKeyboardMarkup{
+			Keyboard: telegram.ReplyKeyboard{
+				telegram.ReplyKeyboardRow{
+					telegram.ReplyKeyboardButton{Text: addButtonText},
+					telegram.ReplyKeyboardButton{Text: listButtonText},
+				},
+				telegram.ReplyKeyboardRow{
+					telegram.ReplyKeyboardButton{Text: quietHoursButtonText},
+					telegram.ReplyKeyboardButton{Text: clearButtonText},
+				},
+			},
+			ResizeKeyboard: true,
+		},
+	}
+}
+
+// confirmKeyboard is shown while destructive action waits for confirmation
+func confirmKeyboard() *telegram.ReplyMarkup {
+	return &telegram.ReplyMarkup{
+		ReplyKeyboardMarkup: &telegram.ReplyKeyboardMarkup{
+			Keyboard: telegram.ReplyKeyboard{
+				telegram.ReplyKeyboardRow{
+					telegram.ReplyKeyboardButton{Text: yesButtonText},
+					telegram.ReplyKeyboardButton{Text: noButtonText},
+				},
+			},
+			ResizeKeyboard:  true,
+			OneTimeKeyboard: true,
+		},
+	}
+}
+
+// parseVkId returns id or domain of VK profile link like
+// https://vk.com/durov, or arg itself if it's not a link
+func parseVkId(arg string) string {
+	vkId := strings.TrimPrefix(strings.TrimSpace(arg), "@")
+	link := strings.TrimPrefix(strings.TrimPrefix(vkId, "https://"), "http://")
+	for _, host := range []string{"vk.com/", "www.vk.com/", "m.vk.com/", "vk.ru/", "m.vk.ru/"} {
+		if strings.HasPrefix(link, host) {
+			vkId = strings.TrimPrefix(link, host)
+			if i := strings.IndexAny(vkId, "/?#"); i != -1 {
+				vkId = vkId[:i]
+			}
+			break
+		}
+	}
+	// Links to profiles without domains are like vk.com/id1
+	if id := strings.TrimPrefix(vkId, "id"); id != vkId {
+		if _, err := strconv.Atoi(id); err == nil {
+			return id
+		}
+	}
+	return vkId
+}
+
+// Spotter holds what command handlers need besides telegram.Context
+type Spotter struct {
+	vk        VKClient
+	dashboard *Dashboard
+}
+
+func NewSpotter(vk VKClient, dashboard *Dashboard) *Spotter {
+	return &Spotter{
+		vk:        vk,
+		dashboard: dashboard,
+	}
+}
+
+// router routes commands of owners in private chats to their handlers
+func (spotter *Spotter) router() *telegram.Router {
+	router := telegram.NewRouter()
+	router.Use(
+		telegram.Recover(),
+		telegram.Logger(),
+		telegram.Authorize(func(ctx *telegram.Context) bool {
+			if ctx.CallbackQuery != nil {
+				from := ctx.CallbackQuery.From
+				return from != nil && getConfig().isOwner(from.Id)
+			}
+			message := ctx.Message
+			return message.From != nil && getConfig().isOwner(message.From.Id) && message.Chat.Type == "private"
+		}),
+		telegram.RateLimit(time.Second, 5, "⏳ Too many commands, try again later"),
+		telegram.NewConversations(conversationTimeout, "⌛ No answer, cancelled").Middleware(),
+	)
+
+	router.Handle(telegram.Route{
+		Command: "/start",
+		Handler: spotter.handleStart,
+	})
+	router.Handle(telegram.Route{
+		Command:     "/add",
+		ButtonTexts: []string{addButtonText},
+		Description: "Add VK users by ids, domains or links",
+		Handler:     spotter.handleAdd,
+	})
+	router.Handle(telegram.Route{
+		Command:     "/remove",
+		Description: "Remove VK users from tracing list",
+		Handler:     spotter.handleRemove,
+	})
+	router.Handle(telegram.Route{
+		Command:     "/list",
+		Aliases:     []string{"📝"},
+		ButtonTexts: []string{listButtonText},
+		Description: "Show tracing list",
+		Handler:     spotter.handleList,
+	})
+	router.Handle(telegram.Route{
+		Command:     "/clear",
+		Aliases:     []string{"♻️"},
+		ButtonTexts: []string{clearButtonText},
+		Description: "Clear tracing list",
+		Handler:     spotter.handleClear,
+	})
+	router.Handle(telegram.Route{
+		Command:     "/quiet",
+		ButtonTexts: []string{quietHoursButtonText},
+		Description: "Set quiet hours like 23:00-07:00 or off",
+		Handler:     spotter.handleQuietHours,
+	})
+	router.Handle(telegram.Route{
+		Command:     "/cancel",
+		Description: "Cancel current question",
+		Handler:     spotter.handleCancel,
+	})
+	router.Handle(telegram.Route{
+		Command:     "/dashboard",
+		Description: "Pin dashboard with live status",
+		Handler:     spotter.handleDashboard,
+	})
+	router.NotFound(func(ctx *telegram.Context) {
+		ctx.Reply("ℹ️ Unknown command", nil)
+	})
+
+	router.SetCallbackCodec(callbackCodec)
+	router.HandleCallback(repeatAction{}, spotter.handleRepeat)
+	router.HandleCallback(noopAction{}, func(ctx *telegram.Context) {
+		ctx.Answer("", false)
+	})
+	router.CallbackNotFound(func(ctx *telegram.Context) {
+		ctx.Answer("ℹ️ Button is outdated", false)
+	})
+
+	return router
+}
+
+// publishCommands sets Telegram's commands menu to the router's commands. Bot
+// is only used in private chats, so menu is set for them
+func publishCommands(ctx context.Context, bot *telegram.Bot, router *telegram.Router) error {
+	_, err := bot.SetMyCommandsContext(ctx, router.Commands(), &telegram.MyCommandsConfig{
+		Scope: &telegram.BotCommandScope{Type: telegram.BotCommandScopeAllPrivateChats},
+	})
+	return err
+}
+
+func (spotter *Spotter) handleStart(ctx *telegram.Context) {
+	ctx.Reply("👋 Hello", &telegram.SendMessageConfig{ReplyMarkup: mainKeyboard()})
+}
+
+func (spotter *Spotter) handleAdd(ctx *telegram.Context) {
+	if len(ctx.Args) == 0 {
+		ctx.Ask("🔗 Send VK id or link", nil, spotter.answerAdd)
+		return
+	}
+	spotter.addTargets(ctx, ctx.Args)
+}
+
+func (spotter *Spotter) answerAdd(ctx *telegram.Context) {
+	args := strings.Fields(ctx.Message.Text)
+	if len(args) == 0 {
+		ctx.Ask("🔗 Send VK id or link", nil, spotter.answerAdd)
+		return
+	}
+	spotter.addTargets(ctx, args)
+}
+
+// addTargets adds VK users by ids, domains or profile links
+func (spotter *Spotter) addTargets(ctx *telegram.Context, args []string) {
+	userIdsToGet := []string{}
+	for _, arg := range args {
+		vkIdOrDomain := parseVkId(arg)
+		found := false
+		for _, userIdToGet := range userIdsToGet {
+			if strings.EqualFold(userIdToGet, vkIdOrDomain) {
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+		if vkIdOrDomain != "" {
+			userIdsToGet = append(userIdsToGet, vkIdOrDomain)
+		}
+	}
+
+	users, err := spotter.vk.GetUsers(ctx, userIdsToGet)
+	if err != nil {
+		log.Println(err.Error())
+		return
+	}
+
+	// Every id gets exactly one reply, they're sent concurrently
+	sendingMessages := sync.WaitGroup{}
+	reply := func(text string) {
+		sendingMessages.Add(1)
+		go func() {
+			defer sendingMessages.Done()
+			ctx.Reply(text, nil)
+		}()
+	}
+
+	for _, user := range users {
+		var domainIsPrimary bool
+		for i, id := range userIdsToGet {
+			// Domains are case-insensitive, VK returns them lowercase
+			if id != "" && strings.EqualFold(id, user.Domain) {
+				domainIsPrimary = true
+				userIdsToGet[i] = ""
+			} else if id == strconv.Itoa(user.Id) {
+				domainIsPrimary = false
+				userIdsToGet[i] = ""
+			}
+		}
+
+		targetsMutex.Lock()
+		if targets.find(user.Id) != nil {
+			targetsMutex.Unlock()
+			var replyText string
+			if domainIsPrimary {
+				replyText = fmt.Sprintf("ℹ️ %s (%s %s) Already added", user.Domain, user.FirstName, user.LastName)
+			} else {
+				replyText = fmt.Sprintf("ℹ️ %d (%s %s) Already added", user.Id, user.FirstName, user.LastName)
+			}
+			reply(replyText)
+			continue
+		}
+
+		if user.Online == 1 {
+			targetsMutex.Unlock()
+			var replyText string
+			if domainIsPrimary {
+				replyText = fmt.Sprintf("✉️ %s (%s %s) Online", user.Domain, user.FirstName, user.LastName)
+			} else {
+				replyText = fmt.Sprintf("✉️ %d (%s %s) Online", user.Id, user.FirstName, user.LastName)
+			}
+			reply(replyText)
+			continue
+		}
+
+		targets.add(&Target{
+			Id:              user.Id,
+			Domain:          user.Domain,
+			DomainIsPrimary: domainIsPrimary,
+			FirstName:       user.FirstName,
+			LastName:        user.LastName,
+			LastSeenTime:    user.LastSeen.Time,
+		})
+		targetsMutex.Unlock()
+
+		var replyText string
+		if domainIsPrimary {
+			replyText = fmt.Sprintf("✅ %s (%s %s) Added", user.Domain, user.FirstName, user.LastName)
+		} else {
+			replyText = fmt.Sprintf("✅ %d (%s %s) Added", user.Id, user.FirstName, user.LastName)
+		}
+		reply(replyText)
+	}
+	for _, id := range userIdsToGet {
+		if id != "" {
+			reply(fmt.Sprintf("❌ %s Not found", id))
+		}
+	}
+
+	sendingMessages.Wait()
+	spotter.dashboard.touch()
+}
+
+func (spotter *Spotter) handleRemove(ctx *telegram.Context) {
+	if len(ctx.Args) == 0 {
+		ctx.Reply("ℹ️ No arguments", nil)
+		return
+	}
+
+	for _, arg := range ctx.Args {
+		vkIdOrDomain := parseVkId(arg)
+		var removed *Target
+		targetsMutex.Lock()
+		for _, target := range targets {
+			// VK domains are case-insensitive, like in /add
+			if strings.EqualFold(target.Domain, vkIdOrDomain) || strconv.Itoa(target.Id) == vkIdOrDomain {
+				removed = target
+				targets.remove(target.Id)
+				break
+			}
+		}
+		targetsMutex.Unlock()
+
+		if removed == nil {
+			ctx.Reply(fmt.Sprintf("❌ %s Not found in tracing list", vkIdOrDomain), nil)
+			continue
+		}
+		var replyText string
+		if removed.DomainIsPrimary {
+			replyText = fmt.Sprintf("✅ %s (%s %s) Removed", removed.Domain, removed.FirstName, removed.LastName)
+		} else {
+			replyText = fmt.Sprintf("✅ %d (%s %s) Removed", removed.Id, removed.FirstName, removed.LastName)
+		}
+		ctx.Reply(replyText, nil)
+	}
+	spotter.dashboard.touch()
+}
+
+func (spotter *Spotter) handleClear(ctx *telegram.Context) {
+	targetsMutex.Lock()
+	targetsCount := len(targets)
+	targetsMutex.Unlock()
+	if targetsCount == 0 {
+		ctx.Reply(fmt.Sprintf("ℹ️ Tracing list is empty"), nil)
+		return
+	}
+
+	confirmText := fmt.Sprintf("❓ Remove all %d users from tracing list?", targetsCount)
+	ctx.Ask(confirmText, &telegram.SendMessageConfig{ReplyMarkup: confirmKeyboard()}, spotter.answerClear)
+}
+
+func (spotter *Spotter) answerClear(ctx *telegram.Context) {
+	if ctx.Message.Text != yesButtonText {
+		ctx.Reply("ℹ️ Cancelled", &telegram.SendMessageConfig{ReplyMarkup: mainKeyboard()})
+		return
+	}
+
+	targetsMutex.Lock()
+	targets.clear()
+	targetsMutex.Unlock()
+	spotter.dashboard.touch()
+	ctx.Reply("✅ Tracing list cleared", &telegram.SendMessageConfig{ReplyMarkup: mainKeyboard()})
+}
+
+func (spotter *Spotter) handleQuietHours(ctx *telegram.Context) {
+	if len(ctx.Args) == 0 {
+		askText := fmt.Sprintf("🌙 Send quiet hours like 23:00-07:00 or %s, now they're %s", quietHoursOff, getConfig().QuietHours)
+		ctx.Ask(askText, nil, spotter.answerQuietHours)
+		return
+	}
+	spotter.setQuietHours(ctx, strings.Join(ctx.Args, ""))
+}
+
+func (spotter *Spotter) answerQuietHours(ctx *telegram.Context) {
+	spotter.setQuietHours(ctx, strings.ReplaceAll(ctx.Message.Text, " ", ""))
+}
+
+func (spotter *Spotter) setQuietHours(ctx *telegram.Context, value string) {
+	err := setQuietHoursOverride(strings.ToLower(value))
+	if err != nil {
+		ctx.Ask("❌ "+err.Error()+", try again", nil, spotter.answerQuietHours)
+		return
+	}
+	ctx.Reply(fmt.Sprintf("✅ Quiet hours are %s", getConfig().QuietHours), nil)
+}
+
+func (spotter *Spotter) handleCancel(ctx *telegram.Context) {
+	if !ctx.EndConversation() {
+		ctx.Reply("ℹ️ Nothing to cancel", nil)
+		return
+	}
+	ctx.Reply("✅ Cancelled", &telegram.SendMessageConfig{ReplyMarkup: mainKeyboard()})
+}
+
+func (spotter *Spotter) handleList(ctx *telegram.Context) {
+	targetsMutex.Lock()
+	tracedTargets := make(Targets, len(targets))
+	copy(tracedTargets, targets)
+	targetsMutex.Unlock()
+
+	replyText := "📝 Tracing list"
+	if len(tracedTargets) == 0 {
+		replyText += " is empty"
+	} else {
+		replyText += "\n\n"
+	}
+	for i, target := range tracedTargets {
+		if target.DomainIsPrimary {
+			replyText += fmt.Sprintf("%d. %s (%s %s)\n", i+1, target.Domain, target.FirstName, target.LastName)
+		} else {
+			replyText += fmt.Sprintf("%d. %d (%s %s)\n", i+1, target.Id, target.FirstName, target.LastName)
+		}
+	}
+	ctx.Reply(replyText, nil)
+}
+
+func (spotter *Spotter) handleDashboard(ctx *telegram.Context) {
+	err := spotter.dashboard.create(ctx, ctx.Message.Chat.Id)
+	if err != nil {
+		log.Println("Can't create dashboard:", err.Error())
+	}
+}
+
+// notificationKeyboard is inline keyboard of online notification, its repeat
+// button is replaced with a label once it's tapped
+func notificationKeyboard(target *Target, repeatButton telegram.InlineKeyboardButton) *telegram.ReplyMarkup {
+	return &telegram.ReplyMarkup{
+		InlineKeyboardMarkup: &telegram.InlineKeyboardMarkup{
+			InlineKeyboard: telegram.InlineKeyboard{
+				telegram.InlineKeyboardRow{
+					telegram.InlineKeyboardButton{
+						Text: "👤 Open profile", Url: target.profileUrl(),
+					},
+					repeatButton,
+				},
+			},
+		},
+	}
+}
+
+func (spotter *Spotter) handleRepeat(ctx *telegram.Context) {
+	var action repeatAction
+	err := ctx.Decode(&action)
+	if err != nil {
+		ctx.Answer("❌ Error occurred", false)
+		return
+	}
+
+	users, err := spotter.vk.GetUsers(ctx, []string{strconv.Itoa(action.Id)})
+	if err != nil {
+		ctx.Answer("❌ Error occurred", false)
+		return
+	}
+
+	if len(users) != 1 {
+		ctx.Answer("❌ Error occurred", false)
+		return
+	}
+
+	user := users[0]
+
+	if user.Online == 1 {
+		ctx.Answer("ℹ️ User is online", false)
+		return
+	}
+
+	target := &Target{
+		Id:              user.Id,
+		Domain:          user.Domain,
+		DomainIsPrimary: action.DomainIsPrimary,
+		FirstName:       user.FirstName,
+		LastName:        user.LastName,
+		LastSeenTime:    user.LastSeen.Time,
+	}
+	targetsMutex.Lock()
+	if targets.find(user.Id) == nil {
+		targets.add(target)
+	}
+	targetsMutex.Unlock()
+	spotter.dashboard.touch()
+
+	ctx.Answer("✅ User added again", false)
+	if ctx.Message == nil {
+		return
+	}
+	repeatedData, err := callbackCodec.Encode(noopAction{})
+	if err != nil {
+		log.Println(err.Error())
+		return
+	}
+	_, err = ctx.Bot.EditMessageReplyMarkupContext(ctx, ctx.Message.Chat.Id, ctx.Message.MessageId, notificationKeyboard(target, telegram.InlineKeyboardButton{
+		Text: "ℹ️ Repeated", CallbackData: repeatedData,
+	}))
+	// Repeat may be tapped twice before the button is replaced
+	if err != nil && !telegram.IsMessageNotModified(err) {
+		log.Println(err.Error())
+	}
+}
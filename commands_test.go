@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"./telegram"
+)
+
+const testOwner = 100
+
+func newTestVKClient() *FakeVKClient {
+	vk := NewFakeVKClient()
+	vk.AddUser(1, "durov", "Pavel", "Durov", 1600000000)
+	vk.AddUser(2, "id2", "Nikolai", "Durov", 1600000000)
+	vk.AddUser(3, "online", "Online", "User", 1600000000)
+	vk.SetOnline(3, 7)
+	return vk
+}
+
+func testMessage(chatId int, text string) *telegram.Message {
+	return &telegram.Message{
+		MessageId: 1,
+		From:      &telegram.User{Id: chatId},
+		Chat:      telegram.Chat{Id: chatId, Type: "private"},
+		Text:      text,
+	}
+}
+
+func TestCommands(t *testing.T) {
+	durov := Target{Id: 1, Domain: "durov", DomainIsPrimary: true, FirstName: "Pavel", LastName: "Durov", LastSeenTime: 1600000000}
+	nikolai := Target{Id: 2, Domain: "id2", FirstName: "Nikolai", LastName: "Durov", LastSeenTime: 1600000000}
+
+	tests := []struct {
+		name        string
+		targets     []Target
+		text        string
+		wantTargets []int
+		wantReplies []string
+	}{
+		{
+			name:        "add by id",
+			text:        "/add 2",
+			wantTargets: []int{2},
+			wantReplies: []string{"✅ 2 (Nikolai Durov) Added"},
+		},
+		{
+			name:        "add by domain",
+			text:        "/add durov",
+			wantTargets: []int{1},
+			wantReplies: []string{"✅ durov (Pavel Durov) Added"},
+		},
+		{
+			name:        "add by link",
+			text:        "/add https://vk.com/durov vk.com/id2",
+			wantTargets: []int{1, 2},
+			wantReplies: []string{"✅ 2 (Nikolai Durov) Added", "✅ durov (Pavel Durov) Added"},
+		},
+		{
+			name:        "add by link with uppercase domain",
+			text:        "/add https://vk.com/Durov",
+			wantTargets: []int{1},
+			wantReplies: []string{"✅ durov (Pavel Durov) Added"},
+		},
+		{
+			name:        "add duplicates",
+			text:        "/add durov durov @Durov",
+			wantTargets: []int{1},
+			wantReplies: []string{"✅ durov (Pavel Durov) Added"},
+		},
+		{
+			name:        "add already added",
+			targets:     []Target{durov},
+			text:        "/add 1",
+			wantTargets: []int{1},
+			wantReplies: []string{"ℹ️ 1 (Pavel Durov) Already added"},
+		},
+		{
+			name:        "add online",
+			text:        "/add online",
+			wantTargets: []int{},
+			wantReplies: []string{"✉️ online (Online User) Online"},
+		},
+		{
+			name:        "add unknown",
+			text:        "/add 2 nobody",
+			wantTargets: []int{2},
+			wantReplies: []string{"✅ 2 (Nikolai Durov) Added", "❌ nobody Not found"},
+		},
+		{
+			name:        "add without arguments asks",
+			text:        "/add",
+			wantTargets: []int{},
+			wantReplies: []string{"🔗 Send VK id or link"},
+		},
+		{
+			name:        "remove by domain",
+			targets:     []Target{durov, nikolai},
+			text:        "/remove durov",
+			wantTargets: []int{2},
+			wantReplies: []string{"✅ durov (Pavel Durov) Removed"},
+		},
+		{
+			name:        "remove by uppercase domain",
+			targets:     []Target{durov, nikolai},
+			text:        "/remove Durov",
+			wantTargets: []int{2},
+			wantReplies: []string{"✅ durov (Pavel Durov) Removed"},
+		},
+		{
+			name:        "remove by link",
+			targets:     []Target{durov, nikolai},
+			text:        "/remove https://vk.com/id2",
+			wantTargets: []int{1},
+			wantReplies: []string{"✅ 2 (Nikolai Durov) Removed"},
+		},
+		{
+			name:        "remove unknown",
+			targets:     []Target{durov},
+			text:        "/remove 5",
+			wantTargets: []int{1},
+			wantReplies: []string{"❌ 5 Not found in tracing list"},
+		},
+		{
+			name:        "remove without arguments",
+			targets:     []Target{durov},
+			text:        "/remove",
+			wantTargets: []int{1},
+			wantReplies: []string{"ℹ️ No arguments"},
+		},
+		{
+			name:        "list",
+			targets:     []Target{durov, nikolai},
+			text:        "/list",
+			wantTargets: []int{1, 2},
+			wantReplies: []string{"📝 Tracing list\n\n1. durov (Pavel Durov)\n2. 2 (Nikolai Durov)\n"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useTestGlobals(t, testOwner)
+			for i := range test.targets {
+				target := test.targets[i]
+				targets.add(&target)
+			}
+			fakeTelegram := newFakeTelegram(t)
+			router := NewSpotter(newTestVKClient(), nil).router()
+
+			router.HandleMessage(context.Background(), fakeTelegram.bot(), testMessage(testOwner, test.text))
+
+			if ids := targetIds(&targets); !reflect.DeepEqual(ids, test.wantTargets) {
+				t.Errorf("targets = %v, want %v", ids, test.wantTargets)
+			}
+			// Replies to /add are sent concurrently
+			replies := fakeTelegram.Texts(testOwner)
+			sort.Strings(replies)
+			if !reflect.DeepEqual(replies, test.wantReplies) {
+				t.Errorf("replies = %q, want %q", replies, test.wantReplies)
+			}
+		})
+	}
+}
+
+func TestCommandsIgnoreStrangers(t *testing.T) {
+	useTestGlobals(t, testOwner)
+	fakeTelegram := newFakeTelegram(t)
+	router := NewSpotter(newTestVKClient(), nil).router()
+
+	router.HandleMessage(context.Background(), fakeTelegram.bot(), testMessage(testOwner+1, "/add durov"))
+
+	if ids := targetIds(&targets); len(ids) != 0 {
+		t.Errorf("targets = %v, want none", ids)
+	}
+	if requests := fakeTelegram.Requests("sendMessage"); len(requests) != 0 {
+		t.Errorf("%d messages sent to stranger, want none", len(requests))
+	}
+}
+
+func TestRepeat(t *testing.T) {
+	tests := []struct {
+		name        string
+		action      repeatAction
+		targets     []int
+		wantTargets []int
+		wantAnswer  string
+		wantEdited  bool
+	}{
+		{
+			name:        "offline user",
+			action:      repeatAction{Id: 1, DomainIsPrimary: true},
+			wantTargets: []int{1},
+			wantAnswer:  "✅ User added again",
+			wantEdited:  true,
+		},
+		{
+			name:        "already traced user",
+			action:      repeatAction{Id: 1},
+			targets:     []int{1},
+			wantTargets: []int{1},
+			wantAnswer:  "✅ User added again",
+			wantEdited:  true,
+		},
+		{
+			name:        "online user",
+			action:      repeatAction{Id: 3},
+			wantTargets: []int{},
+			wantAnswer:  "ℹ️ User is online",
+		},
+		{
+			name:        "unknown user",
+			action:      repeatAction{Id: 5},
+			wantTargets: []int{},
+			wantAnswer:  "❌ Error occurred",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useTestGlobals(t, testOwner)
+			for _, id := range test.targets {
+				targets.add(&Target{Id: id})
+			}
+			fakeTelegram := newFakeTelegram(t)
+			router := NewSpotter(newTestVKClient(), nil).router()
+			data, err := callbackCodec.Encode(test.action)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			router.HandleCallbackQuery(context.Background(), fakeTelegram.bot(), &telegram.CallbackQuery{
+				Id:      "query",
+				From:    &telegram.User{Id: testOwner},
+				Message: testMessage(testOwner, "✉️ durov (Pavel Durov) Online"),
+				Data:    data,
+			})
+
+			if ids := targetIds(&targets); !reflect.DeepEqual(ids, test.wantTargets) {
+				t.Errorf("targets = %v, want %v", ids, test.wantTargets)
+			}
+			answers := fakeTelegram.Requests("answerCallbackQuery")
+			if len(answers) != 1 || answers[0].Get("text") != test.wantAnswer {
+				t.Errorf("answers = %v, want %q", answers, test.wantAnswer)
+			}
+			edits := fakeTelegram.Requests("editMessageReplyMarkup")
+			if edited := len(edits) == 1; edited != test.wantEdited {
+				t.Fatalf("keyboard edited = %v, want %v", edited, test.wantEdited)
+			}
+			// Profile link is kept when Repeat is replaced with a label
+			for _, edit := range edits {
+				replyMarkup := edit.Get("reply_markup")
+				for _, want := range []string{`"url":"https://vk.com/durov"`, `"text":"ℹ️ Repeated"`} {
+					if !strings.Contains(replyMarkup, want) {
+						t.Errorf("reply_markup = %s, want it to contain %s", replyMarkup, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRepeatRejectsForgedData(t *testing.T) {
+	useTestGlobals(t, testOwner)
+	fakeTelegram := newFakeTelegram(t)
+	router := NewSpotter(newTestVKClient(), nil).router()
+	forgedData, err := telegram.NewCallbackCodec([]byte("forged")).Encode(repeatAction{Id: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router.HandleCallbackQuery(context.Background(), fakeTelegram.bot(), &telegram.CallbackQuery{
+		Id:   "query",
+		From: &telegram.User{Id: testOwner},
+		Data: forgedData,
+	})
+
+	if ids := targetIds(&targets); len(ids) != 0 {
+		t.Errorf("targets = %v, want none", ids)
+	}
+}
+
+// TestDispatchInterleavedCommands sends a burst of commands of two owners and
+// checks that commands of each one are applied in order
+func TestDispatchInterleavedCommands(t *testing.T) {
+	const otherOwner = testOwner + 1
+	useTestGlobals(t, testOwner, otherOwner)
+	fakeTelegram := newFakeTelegram(t)
+	bot := fakeTelegram.bot()
+	router := NewSpotter(newTestVKClient(), nil).router()
+	dispatcher := telegram.NewDispatcher(updateWorkers, func(update telegram.Update) {
+		handleUpdate(context.Background(), bot, router, nil, update)
+	})
+
+	commands := []struct {
+		chatId int
+		text   string
+	}{
+		{testOwner, "/add durov"},
+		{otherOwner, "/add 2"},
+		{testOwner, "/remove durov"},
+		{otherOwner, "/remove 2"},
+		{testOwner, "/add durov"},
+		{otherOwner, "/add 2"},
+		{otherOwner, "/remove 2"},
+	}
+	for i, command := range commands {
+		dispatcher.Dispatch(telegram.Update{UpdateId: i, Message: testMessage(command.chatId, command.text)})
+	}
+	dispatcher.Wait()
+
+	if ids := targetIds(&targets); !reflect.DeepEqual(ids, []int{1}) {
+		t.Errorf("targets = %v, want [1]", ids)
+	}
+	wantReplies := map[int][]string{
+		testOwner: {
+			"✅ durov (Pavel Durov) Added",
+			"✅ durov (Pavel Durov) Removed",
+			"✅ durov (Pavel Durov) Added",
+		},
+		otherOwner: {
+			"✅ 2 (Nikolai Durov) Added",
+			"✅ 2 (Nikolai Durov) Removed",
+			"✅ 2 (Nikolai Durov) Added",
+			"✅ 2 (Nikolai Durov) Removed",
+		},
+	}
+	for chatId, want := range wantReplies {
+		if replies := fakeTelegram.Texts(chatId); !reflect.DeepEqual(replies, want) {
+			t.Errorf("replies in chat %d = %q, want %q", chatId, replies, want)
+		}
+	}
+}
@@ -0,0 +1,534 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Config is read from defaults, then from config file, then from environment
+// variables, each one overriding the previous
+type Config struct {
+	TelegramToken string
+	VKToken       string
+	Owners        []int
+	PollInterval  time.Duration
+	VKApiUrl      string
+	VKApiVersion  string
+	VKLang        string
+	StateFile     string
+	MetricsAddr   string
+	UpdatesMode   string
+	WebhookUrl    string
+	WebhookAddr   string
+	WebhookSecret string
+	QuietHours    *QuietHours
+	Templates     Templates
+}
+
+// QuietHours is a daily period when notifications are sent silently. Start
+// and End are minutes since midnight, the period may cross midnight
+type QuietHours struct {
+	Start int
+	End   int
+}
+
+func (quietHours *QuietHours) contains(t time.Time) bool {
+	if quietHours == nil {
+		return false
+	}
+	minute := t.Hour()*60 + t.Minute()
+	if quietHours.Start <= quietHours.End {
+		return minute >= quietHours.Start && minute < quietHours.End
+	}
+	return minute >= quietHours.Start || minute < quietHours.End
+}
+
+// String returns quiet hours in QUIET_HOURS format, or off if there are none
+func (quietHours *QuietHours) String() string {
+	if quietHours == nil {
+		return quietHoursOff
+	}
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", quietHours.Start/60, quietHours.Start%60, quietHours.End/60, quietHours.End%60)
+}
+
+type Templates struct {
+	Online *template.Template
+}
+
+// templateData is what templates are executed with. Templates are sent in
+// HTML parse mode, so strings are escaped
+type templateData struct {
+	Id         int
+	Domain     string
+	Name       string
+	FirstName  string
+	LastName   string
+	ProfileUrl string
+	// LastSeen and Platform are empty if VK doesn't report them
+	LastSeen string
+	Platform string
+}
+
+// Updates are received either with getUpdates long polling or with webhook
+const (
+	updatesModePolling = "polling"
+	updatesModeWebhook = "webhook"
+)
+
+const defaultOnlineTemplate = `✉️ <a href="{{.ProfileUrl}}">{{.Name}}</a> ({{.FirstName}} {{.LastName}}) Online
+{{- if .LastSeen}}
+🕒 Last seen {{.LastSeen}}
+{{- end}}
+{{- if .Platform}}
+{{.Platform}}
+{{- end}}`
+
+const quietHoursOff = "off"
+
+// webhookSecretRegexp matches secret tokens accepted by setWebhook
+var webhookSecretRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+
+// defaultTemplates are used when templates aren't set in config file, and
+// when ones from there fail
+var defaultTemplates = Templates{
+	Online: template.Must(template.New("online").Parse(defaultOnlineTemplate)),
+}
+
+var (
+	config      = defaultConfig()
+	configMutex sync.RWMutex
+	// quietHoursOverride is set by owners with /quiet and takes precedence
+	// over quiet hours from config. It's a period in QUIET_HOURS format or
+	// off, and empty if quiet hours aren't overridden
+	quietHoursOverride string
+)
+
+// getConfig returns current config which may be replaced on reload, so it
+// must not be cached for long
+func getConfig() *Config {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+	return config
+}
+
+func setConfig(newConfig *Config) {
+	configMutex.Lock()
+	config = newConfig
+	configMutex.Unlock()
+}
+
+// reloadConfig re-reads config and applies settings which can be changed
+// without restart. Invalid config is rejected and current one is kept
+func reloadConfig(path string) {
+	newConfig, err := loadConfig(path)
+	if err != nil {
+		log.Println("Config reload rejected:", err.Error())
+		return
+	}
+
+	oldConfig := getConfig()
+	if newConfig.TelegramToken != oldConfig.TelegramToken ||
+		newConfig.VKToken != oldConfig.VKToken ||
+		newConfig.VKApiUrl != oldConfig.VKApiUrl ||
+		newConfig.VKApiVersion != oldConfig.VKApiVersion ||
+		newConfig.VKLang != oldConfig.VKLang ||
+		newConfig.StateFile != oldConfig.StateFile ||
+		newConfig.MetricsAddr != oldConfig.MetricsAddr ||
+		newConfig.UpdatesMode != oldConfig.UpdatesMode ||
+		newConfig.WebhookUrl != oldConfig.WebhookUrl ||
+		newConfig.WebhookAddr != oldConfig.WebhookAddr ||
+		newConfig.WebhookSecret != oldConfig.WebhookSecret {
+		log.Println("Tokens, VK API, state file and HTTP settings are applied only on restart")
+	}
+
+	reloadedConfig := *oldConfig
+	reloadedConfig.PollInterval = newConfig.PollInterval
+	reloadedConfig.Owners = newConfig.Owners
+	reloadedConfig.QuietHours = newConfig.QuietHours
+	reloadedConfig.Templates = newConfig.Templates
+	configMutex.Lock()
+	if quietHoursOverride != "" {
+		// Override was validated when it was set
+		reloadedConfig.QuietHours, _ = parseQuietHoursOverride(quietHoursOverride)
+	}
+	config = &reloadedConfig
+	configMutex.Unlock()
+	log.Println("Config reloaded")
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		PollInterval: time.Second * 7,
+		VKApiUrl:     "https://api.vk.com/method/",
+		VKApiVersion: "5.126",
+		VKLang:       "ru",
+		StateFile:    "state.json",
+		UpdatesMode:  updatesModePolling,
+		Templates:    defaultTemplates,
+	}
+}
+
+func (config *Config) isOwner(id int) bool {
+	for _, owner := range config.Owners {
+		if owner == id {
+			return true
+		}
+	}
+	return false
+}
+
+// loadConfig reads config file at path if it's not empty and applies
+// environment variables over it
+func loadConfig(path string) (*Config, error) {
+	config := defaultConfig()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		values, err := parseToml(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err.Error())
+		}
+		err = config.applyFile(values)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err.Error())
+		}
+	}
+
+	err := config.applyEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	err = config.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+func (config *Config) applyFile(values map[string]tomlValue) error {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	// Errors are reported in order of lines
+	sort.Slice(keys, func(i, j int) bool {
+		return values[keys[i]].line < values[keys[j]].line
+	})
+
+	errs := []error{}
+	for _, key := range keys {
+		value := values[key]
+		err := config.applyFileValue(key, value.value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("line %d: %s: %s", value.line, key, err.Error()))
+		}
+	}
+
+	// Missing bound would silently default to 00:00
+	start, hasStart := values["quiet_hours.start"]
+	end, hasEnd := values["quiet_hours.end"]
+	if hasStart && !hasEnd {
+		errs = append(errs, fmt.Errorf("line %d: quiet_hours: end Not specified", start.line))
+	} else if hasEnd && !hasStart {
+		errs = append(errs, fmt.Errorf("line %d: quiet_hours: start Not specified", end.line))
+	}
+	return errors.Join(errs...)
+}
+
+func (config *Config) applyFileValue(key string, value interface{}) error {
+	var err error
+	switch key {
+	case "telegram.token":
+		config.TelegramToken, err = tomlString(value)
+	case "vk.token":
+		config.VKToken, err = tomlString(value)
+	case "vk.api_url":
+		config.VKApiUrl, err = tomlString(value)
+	case "vk.api_version":
+		config.VKApiVersion, err = tomlString(value)
+	case "vk.lang":
+		config.VKLang, err = tomlString(value)
+	case "owners":
+		array, ok := value.([]interface{})
+		if !ok {
+			return errors.New("must be an array of telegram user ids")
+		}
+		config.Owners = []int{}
+		for _, item := range array {
+			owner, ok := item.(int)
+			if !ok {
+				return errors.New("must be an array of telegram user ids")
+			}
+			config.Owners = append(config.Owners, owner)
+		}
+	case "poll_interval":
+		var pollInterval string
+		pollInterval, err = tomlString(value)
+		if err == nil {
+			config.PollInterval, err = time.ParseDuration(pollInterval)
+		}
+	case "telegram.updates":
+		config.UpdatesMode, err = tomlString(value)
+	case "telegram.webhook_url":
+		config.WebhookUrl, err = tomlString(value)
+	case "telegram.webhook_secret":
+		config.WebhookSecret, err = tomlString(value)
+	case "http.webhook_addr":
+		config.WebhookAddr, err = tomlString(value)
+	case "storage.state_file":
+		config.StateFile, err = tomlString(value)
+	case "http.metrics_addr":
+		config.MetricsAddr, err = tomlString(value)
+	case "quiet_hours.start", "quiet_hours.end":
+		var clock string
+		clock, err = tomlString(value)
+		if err != nil {
+			return err
+		}
+		var minute int
+		minute, err = parseClock(clock)
+		if err != nil {
+			return err
+		}
+		if config.QuietHours == nil {
+			config.QuietHours = new(QuietHours)
+		}
+		if key == "quiet_hours.start" {
+			config.QuietHours.Start = minute
+		} else {
+			config.QuietHours.End = minute
+		}
+	case "templates.online":
+		var text string
+		text, err = tomlString(value)
+		if err == nil {
+			config.Templates.Online, err = template.New("online").Parse(text)
+		}
+	default:
+		return errors.New("unknown key")
+	}
+	return err
+}
+
+func tomlString(value interface{}) (string, error) {
+	stringValue, ok := value.(string)
+	if !ok {
+		return "", errors.New("must be a string")
+	}
+	return stringValue, nil
+}
+
+// lookupEnv returns value of environment variable name, or content of the
+// file in name_FILE variable, so secrets can come from mounted files
+func lookupEnv(name string) (string, bool, error) {
+	if value, ok := os.LookupEnv(name); ok {
+		return value, true, nil
+	}
+	path, ok := os.LookupEnv(name + "_FILE")
+	if !ok {
+		return "", false, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", false, fmt.Errorf("%s_FILE: %s", name, err.Error())
+	}
+	return strings.TrimSpace(string(data)), true, nil
+}
+
+func (config *Config) applyEnv() error {
+	stringVariables := []struct {
+		name  string
+		field *string
+	}{
+		{"TG_TOKEN", &config.TelegramToken},
+		{"VK_TOKEN", &config.VKToken},
+		{"VK_API_URL", &config.VKApiUrl},
+		{"VK_API_VERSION", &config.VKApiVersion},
+		{"VK_LANG", &config.VKLang},
+		{"STATE_FILE", &config.StateFile},
+		{"METRICS_ADDR", &config.MetricsAddr},
+		{"UPDATES_MODE", &config.UpdatesMode},
+		{"WEBHOOK_URL", &config.WebhookUrl},
+		{"WEBHOOK_ADDR", &config.WebhookAddr},
+		{"WEBHOOK_SECRET", &config.WebhookSecret},
+	}
+	errs := []error{}
+	for _, variable := range stringVariables {
+		value, ok, err := lookupEnv(variable.name)
+		if err != nil {
+			errs = append(errs, err)
+		} else if ok {
+			*variable.field = value
+		}
+	}
+
+	// OWNER_ID may be a comma-separated list
+	if value, ok, err := lookupEnv("OWNER_ID"); err != nil {
+		errs = append(errs, err)
+	} else if ok {
+		config.Owners = []int{}
+		for _, ownerIdString := range strings.Split(value, ",") {
+			ownerId, err := strconv.Atoi(strings.TrimSpace(ownerIdString))
+			if err != nil {
+				errs = append(errs, errors.New("OWNER_ID Must be a number or comma-separated numbers"))
+				break
+			}
+			config.Owners = append(config.Owners, ownerId)
+		}
+	}
+
+	if value, ok, err := lookupEnv("POLL_INTERVAL"); err != nil {
+		errs = append(errs, err)
+	} else if ok {
+		pollInterval, err := time.ParseDuration(value)
+		if err != nil {
+			errs = append(errs, errors.New("POLL_INTERVAL Must be a duration like 7s"))
+		} else {
+			config.PollInterval = pollInterval
+		}
+	}
+
+	// QUIET_HOURS is like 23:00-07:00, empty value disables quiet hours
+	if value, ok, err := lookupEnv("QUIET_HOURS"); err != nil {
+		errs = append(errs, err)
+	} else if ok {
+		quietHours, err := parseQuietHours(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("QUIET_HOURS %s", err.Error()))
+		} else {
+			config.QuietHours = quietHours
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (config *Config) validate() error {
+	errs := []error{}
+	if config.TelegramToken == "" {
+		errs = append(errs, errors.New("TG_TOKEN Not specified"))
+	}
+	if config.VKToken == "" {
+		errs = append(errs, errors.New("VK_TOKEN Not specified"))
+	}
+	if len(config.Owners) == 0 {
+		errs = append(errs, errors.New("OWNER_ID Not specified"))
+	}
+	if config.PollInterval < time.Second {
+		errs = append(errs, errors.New("poll interval Must be at least 1s"))
+	}
+	if apiUrl, err := url.Parse(config.VKApiUrl); err != nil || apiUrl.Scheme == "" || apiUrl.Host == "" {
+		errs = append(errs, fmt.Errorf("VK API url %q Must be an absolute url", config.VKApiUrl))
+	}
+	if config.VKApiVersion == "" {
+		errs = append(errs, errors.New("VK API version Must not be empty"))
+	}
+	if config.StateFile == "" {
+		errs = append(errs, errors.New("state file Must not be empty"))
+	}
+	switch config.UpdatesMode {
+	case updatesModePolling:
+	case updatesModeWebhook:
+		if webhookUrl, err := url.Parse(config.WebhookUrl); err != nil || webhookUrl.Scheme != "https" || webhookUrl.Host == "" {
+			errs = append(errs, fmt.Errorf("WEBHOOK_URL %q Must be an absolute https url", config.WebhookUrl))
+		}
+		if config.WebhookAddr == "" {
+			errs = append(errs, errors.New("WEBHOOK_ADDR Not specified"))
+		}
+		// Without secret anyone who knows the url could forge updates
+		if config.WebhookSecret == "" {
+			errs = append(errs, errors.New("WEBHOOK_SECRET Not specified"))
+		} else if !webhookSecretRegexp.MatchString(config.WebhookSecret) {
+			errs = append(errs, errors.New("WEBHOOK_SECRET Must be 1-256 characters A-Z, a-z, 0-9, _ and -"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("UPDATES_MODE %q Must be %s or %s", config.UpdatesMode, updatesModePolling, updatesModeWebhook))
+	}
+	if config.QuietHours != nil && config.QuietHours.Start == config.QuietHours.End {
+		errs = append(errs, errors.New("quiet hours start and end Must differ"))
+	}
+	return errors.Join(errs...)
+}
+
+// parseClock returns minutes since midnight of time like 23:30
+func parseClock(clock string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(clock))
+	if err != nil {
+		return 0, fmt.Errorf("%q Must be time like 23:30", clock)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// parseQuietHoursOverride parses quiet hours set with /quiet, unlike config
+// they may be turned off explicitly
+func parseQuietHoursOverride(value string) (*QuietHours, error) {
+	if value == quietHoursOff {
+		return nil, nil
+	}
+	quietHours, err := parseQuietHours(value)
+	if err != nil {
+		return nil, err
+	}
+	if quietHours == nil {
+		return nil, fmt.Errorf("%q Must be period like 23:00-07:00 or %s", value, quietHoursOff)
+	}
+	if quietHours.Start == quietHours.End {
+		return nil, errors.New("quiet hours start and end Must differ")
+	}
+	return quietHours, nil
+}
+
+// setQuietHoursOverride applies quiet hours set with /quiet to current config
+func setQuietHoursOverride(value string) error {
+	quietHours, err := parseQuietHoursOverride(value)
+	if err != nil {
+		return err
+	}
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	newConfig := *config
+	newConfig.QuietHours = quietHours
+	config = &newConfig
+	quietHoursOverride = quietHours.String()
+	return nil
+}
+
+func getQuietHoursOverride() string {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+	return quietHoursOverride
+}
+
+func parseQuietHours(value string) (*QuietHours, error) {
+	if value == "" {
+		return nil, nil
+	}
+	bounds := strings.Split(value, "-")
+	if len(bounds) != 2 {
+		return nil, fmt.Errorf("%q Must be period like 23:00-07:00", value)
+	}
+	start, err := parseClock(bounds[0])
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseClock(bounds[1])
+	if err != nil {
+		return nil, err
+	}
+	return &QuietHours{start, end}, nil
+}
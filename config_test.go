@@ -0,0 +1,298 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// configEnv are variables read by applyEnv
+var configEnv = []string{
+	"TG_TOKEN", "VK_TOKEN", "VK_API_URL", "VK_API_VERSION", "VK_LANG", "STATE_FILE",
+	"METRICS_ADDR", "UPDATES_MODE", "WEBHOOK_URL", "WEBHOOK_ADDR", "WEBHOOK_SECRET",
+	"OWNER_ID", "POLL_INTERVAL", "QUIET_HOURS",
+}
+
+// clearConfigEnv unsets config variables and their _FILE variants for the
+// test, so environment of the machine doesn't leak into it
+func clearConfigEnv(t *testing.T) {
+	for _, name := range configEnv {
+		for _, variable := range []string{name, name + "_FILE"} {
+			// Setenv restores the previous value on cleanup
+			t.Setenv(variable, "")
+			os.Unsetenv(variable)
+		}
+	}
+}
+
+// writeTestFile writes data to a file in temporary directory of the test and
+// returns its path
+func writeTestFile(t *testing.T, name string, data string) string {
+	path := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(path, []byte(data), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestApplyFile(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		// want changes default config to the expected one
+		want func(config *Config)
+		// wantErr is a substring of the error, config isn't checked if it's set
+		wantErr string
+	}{
+		{
+			name: "values",
+			data: `owners = [1, 2]
+poll_interval = "1m"
+[telegram]
+token = "tg"
+updates = "webhook"
+webhook_url = "https://example.com/hook"
+webhook_secret = "secret"
+[vk]
+token = "vk"
+lang = "en"
+[http]
+webhook_addr = ":8443"`,
+			want: func(config *Config) {
+				config.Owners = []int{1, 2}
+				config.PollInterval = time.Minute
+				config.TelegramToken = "tg"
+				config.UpdatesMode = updatesModeWebhook
+				config.WebhookUrl = "https://example.com/hook"
+				config.WebhookSecret = "secret"
+				config.VKToken = "vk"
+				config.VKLang = "en"
+				config.WebhookAddr = ":8443"
+			},
+		},
+		{
+			name: "quiet hours",
+			data: "[quiet_hours]\nstart = \"23:30\"\nend = \"07:00\"",
+			want: func(config *Config) {
+				config.QuietHours = &QuietHours{23*60 + 30, 7 * 60}
+			},
+		},
+		{
+			name:    "quiet hours without end",
+			data:    "[quiet_hours]\nstart = \"23:00\"",
+			wantErr: "line 2: quiet_hours: end Not specified",
+		},
+		{
+			name:    "quiet hours without start",
+			data:    "[quiet_hours]\nend = \"07:00\"",
+			wantErr: "line 2: quiet_hours: start Not specified",
+		},
+		{
+			name:    "invalid quiet hours",
+			data:    "[quiet_hours]\nstart = \"25:00\"\nend = \"07:00\"",
+			wantErr: `line 2: quiet_hours.start: "25:00" Must be time like 23:30`,
+		},
+		{
+			name:    "unknown key",
+			data:    "[vk]\ntokn = \"vk\"",
+			wantErr: "line 2: vk.tokn: unknown key",
+		},
+		{
+			name:    "string expected",
+			data:    "[vk]\ntoken = 1",
+			wantErr: "line 2: vk.token: must be a string",
+		},
+		{
+			name:    "owners not numbers",
+			data:    "owners = [\"1\"]",
+			wantErr: "line 1: owners: must be an array of telegram user ids",
+		},
+		{
+			name:    "invalid poll interval",
+			data:    "poll_interval = \"7\"",
+			wantErr: "line 1: poll_interval:",
+		},
+		{
+			name:    "errors in order of lines",
+			data:    "a = 1\nb = 2\nc = 3",
+			wantErr: "line 1: a: unknown key\nline 2: b: unknown key\nline 3: c: unknown key",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			values, err := parseToml(test.data)
+			if err != nil {
+				t.Fatalf("parseToml() error = %v", err)
+			}
+			config := defaultConfig()
+
+			err = config.applyFile(values)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("applyFile() error = %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("applyFile() error = %v", err)
+			}
+			want := defaultConfig()
+			test.want(want)
+			if !reflect.DeepEqual(config, want) {
+				t.Errorf("config = %+v, want %+v", config, want)
+			}
+		})
+	}
+}
+
+func TestLookupEnv(t *testing.T) {
+	tests := []struct {
+		name string
+		// env is set before lookup, value of VK_TOKEN_FILE is a file content
+		// rather than its path
+		env       map[string]string
+		want      string
+		wantFound bool
+	}{
+		{
+			name: "unset",
+		},
+		{
+			name:      "variable",
+			env:       map[string]string{"VK_TOKEN": "token"},
+			want:      "token",
+			wantFound: true,
+		},
+		{
+			name:      "empty variable",
+			env:       map[string]string{"VK_TOKEN": ""},
+			wantFound: true,
+		},
+		{
+			name:      "file",
+			env:       map[string]string{"VK_TOKEN_FILE": " token\n"},
+			want:      "token",
+			wantFound: true,
+		},
+		{
+			name:      "variable over file",
+			env:       map[string]string{"VK_TOKEN": "token", "VK_TOKEN_FILE": "file token"},
+			want:      "token",
+			wantFound: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clearConfigEnv(t)
+			for name, value := range test.env {
+				if name == "VK_TOKEN_FILE" {
+					value = writeTestFile(t, "token", value)
+				}
+				t.Setenv(name, value)
+			}
+
+			value, found, err := lookupEnv("VK_TOKEN")
+			if err != nil {
+				t.Fatalf("lookupEnv() error = %v", err)
+			}
+			if value != test.want || found != test.wantFound {
+				t.Errorf("lookupEnv() = %q, %v, want %q, %v", value, found, test.want, test.wantFound)
+			}
+		})
+	}
+
+	t.Run("missing file", func(t *testing.T) {
+		clearConfigEnv(t)
+		t.Setenv("VK_TOKEN_FILE", filepath.Join(t.TempDir(), "missing"))
+
+		_, _, err := lookupEnv("VK_TOKEN")
+		if err == nil || !strings.HasPrefix(err.Error(), "VK_TOKEN_FILE: ") {
+			t.Errorf("lookupEnv() error = %v, want VK_TOKEN_FILE error", err)
+		}
+	})
+}
+
+// TestLoadConfigPrecedence checks that environment overrides config file,
+// which overrides defaults
+func TestLoadConfigPrecedence(t *testing.T) {
+	clearConfigEnv(t)
+	path := writeTestFile(t, "config.toml", `owners = [1]
+poll_interval = "10s"
+[telegram]
+token = "file token"
+[vk]
+token = "file token"
+api_version = "5.131"
+[quiet_hours]
+start = "23:00"
+end = "07:00"`)
+	t.Setenv("TG_TOKEN_FILE", writeTestFile(t, "tg_token", "env token\n"))
+	t.Setenv("POLL_INTERVAL", "20s")
+	t.Setenv("OWNER_ID", "2, 3")
+	t.Setenv("QUIET_HOURS", "")
+
+	config, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		got  interface{}
+		want interface{}
+	}{
+		{"env over file", config.PollInterval, time.Second * 20},
+		{"env file over file", config.TelegramToken, "env token"},
+		{"env list over file", config.Owners, []int{2, 3}},
+		{"empty env disables quiet hours", config.QuietHours, (*QuietHours)(nil)},
+		{"file over default", config.VKApiVersion, "5.131"},
+		{"file without env", config.VKToken, "file token"},
+		{"default", config.VKLang, "ru"},
+	}
+	for _, test := range tests {
+		if !reflect.DeepEqual(test.got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, test.got, test.want)
+		}
+	}
+}
+
+func TestValidateWebhook(t *testing.T) {
+	tests := []struct {
+		name          string
+		webhookSecret string
+		wantErr       string
+	}{
+		{"secret", "Secret_1-2", ""},
+		{"no secret", "", "WEBHOOK_SECRET Not specified"},
+		{"invalid secret", "secret token", "WEBHOOK_SECRET Must be"},
+		{"long secret", strings.Repeat("a", 257), "WEBHOOK_SECRET Must be"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := defaultConfig()
+			config.TelegramToken = "tg"
+			config.VKToken = "vk"
+			config.Owners = []int{1}
+			config.UpdatesMode = updatesModeWebhook
+			config.WebhookUrl = "https://example.com/hook"
+			config.WebhookAddr = ":8443"
+			config.WebhookSecret = test.webhookSecret
+
+			err := config.validate()
+			if test.wantErr == "" {
+				if err != nil {
+					t.Errorf("validate() error = %v", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("validate() error = %v, want %q", err, test.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"./telegram"
+)
+
+// Dashboard is edited at most once per dashboardMinInterval, and every
+// dashboardRefreshInterval anyway to keep "last seen" ages fresh. Spotted
+// targets stay on it for dashboardSpottedTtl after they're removed from
+// tracing list
+const (
+	dashboardMinInterval     = time.Second * 5
+	dashboardRefreshInterval = time.Minute
+	dashboardSpottedTtl      = time.Hour
+)
+
+var vkPlatforms = map[int]string{
+	1: "📱 Mobile",
+	2: "📱 iPhone",
+	3: "📱 iPad",
+	4: "📱 Android",
+	5: "📱 Windows Phone",
+	6: "💻 Windows 10",
+	7: "💻 Web",
+}
+
+type spottedTarget struct {
+	target   *Target
+	presence presence
+	time     time.Time
+}
+
+// Dashboard is a pinned message in owners' chats which is edited in place to
+// show current presence of every target
+type Dashboard struct {
+	bot       *telegram.Bot
+	targets   *Targets
+	clock     Clock
+	messages  map[int]int
+	texts     map[int]string
+	presences map[int]presence
+	spotted   map[int]spottedTarget
+	changed   chan struct{}
+	mutex     sync.Mutex
+}
+
+// NewDashboard takes ids of dashboard messages by chat ids saved in state
+func NewDashboard(bot *telegram.Bot, targets *Targets, clock Clock, messages map[int]int) *Dashboard {
+	if messages == nil {
+		messages = map[int]int{}
+	}
+	return &Dashboard{
+		bot:       bot,
+		targets:   targets,
+		clock:     clock,
+		messages:  messages,
+		texts:     map[int]string{},
+		presences: map[int]presence{},
+		spotted:   map[int]spottedTarget{},
+		changed:   make(chan struct{}, 1),
+	}
+}
+
+// messageIds returns dashboard messages by chat ids to save in state
+func (dashboard *Dashboard) messageIds() map[int]int {
+	if dashboard == nil {
+		return nil
+	}
+	dashboard.mutex.Lock()
+	defer dashboard.mutex.Unlock()
+	messages := make(map[int]int, len(dashboard.messages))
+	for chatId, messageId := range dashboard.messages {
+		messages[chatId] = messageId
+	}
+	return messages
+}
+
+// touch schedules dashboard update, e.g. when tracing list is changed
+func (dashboard *Dashboard) touch() {
+	if dashboard == nil {
+		return
+	}
+	select {
+	case dashboard.changed <- struct{}{}:
+	default:
+	}
+}
+
+// observe remembers presence reported by VK, dashboard is updated only if it
+// changed
+func (dashboard *Dashboard) observe(p presence) {
+	if dashboard == nil {
+		return
+	}
+	dashboard.mutex.Lock()
+	previous, found := dashboard.presences[p.Id]
+	dashboard.presences[p.Id] = p
+	dashboard.mutex.Unlock()
+	if !found || previous != p {
+		dashboard.touch()
+	}
+}
+
+// spot moves target which appeared online from tracing list to spotted ones
+func (dashboard *Dashboard) spot(target *Target, p presence) {
+	if dashboard == nil {
+		return
+	}
+	dashboard.mutex.Lock()
+	dashboard.spotted[target.Id] = spottedTarget{target, p, dashboard.clock.Now()}
+	delete(dashboard.presences, target.Id)
+	dashboard.mutex.Unlock()
+	dashboard.touch()
+}
+
+// create sends and pins a new dashboard into chatId, the previous one is
+// deleted
+func (dashboard *Dashboard) create(ctx context.Context, chatId int) error {
+	text := dashboard.render()
+	message, err := dashboard.bot.SendMessageContext(ctx, chatId, text, nil)
+	if err != nil {
+		return err
+	}
+	_, err = dashboard.bot.PinChatMessageContext(ctx, chatId, message.MessageId, true)
+	if err != nil {
+		log.Println("Can't pin dashboard:", err.Error())
+	}
+
+	dashboard.mutex.Lock()
+	previousMessageId, found := dashboard.messages[chatId]
+	dashboard.messages[chatId] = message.MessageId
+	dashboard.texts[chatId] = text
+	dashboard.mutex.Unlock()
+
+	if found {
+		_, err = dashboard.bot.DeleteMessageContext(ctx, chatId, previousMessageId)
+		if err != nil {
+			log.Println("Can't delete previous dashboard:", err.Error())
+		}
+	}
+	return nil
+}
+
+func (dashboard *Dashboard) start(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-dashboard.changed:
+		case <-dashboard.clock.After(dashboardRefreshInterval):
+		}
+		dashboard.update(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-dashboard.clock.After(dashboardMinInterval):
+		}
+	}
+}
+
+// update edits dashboards which text differs from the rendered one
+func (dashboard *Dashboard) update(ctx context.Context) {
+	text := dashboard.render()
+
+	dashboard.mutex.Lock()
+	outdated := map[int]int{}
+	for chatId, messageId := range dashboard.messages {
+		if dashboard.texts[chatId] != text {
+			outdated[chatId] = messageId
+		}
+	}
+	dashboard.mutex.Unlock()
+
+	for chatId, messageId := range outdated {
+		_, err := dashboard.bot.EditMessageTextContext(ctx, chatId, messageId, text, nil)
+		if err != nil && !telegram.IsMessageNotModified(err) {
+			if telegram.IsMessageToEditNotFound(err) || telegram.IsChatNotFound(err) || telegram.IsBlockedByUser(err) {
+				log.Printf("Dashboard in chat %d is gone, it's no longer updated: %s", chatId, err.Error())
+				dashboard.mutex.Lock()
+				if dashboard.messages[chatId] == messageId {
+					delete(dashboard.messages, chatId)
+					delete(dashboard.texts, chatId)
+				}
+				dashboard.mutex.Unlock()
+			} else {
+				log.Println(err.Error())
+			}
+			continue
+		}
+		dashboard.mutex.Lock()
+		if dashboard.messages[chatId] == messageId {
+			dashboard.texts[chatId] = text
+		}
+		dashboard.mutex.Unlock()
+	}
+}
+
+// targetStatus is the latest known presence of traced target, or of spotted
+// one if spottedTime isn't zero
+type targetStatus struct {
+	target      *Target
+	presence    presence
+	spottedTime time.Time
+}
+
+// statuses returns traced targets in tracing list order followed by spotted
+// ones, most recent first
+func (dashboard *Dashboard) statuses() []targetStatus {
+	now := dashboard.clock.Now()
+
+	targetsMutex.Lock()
+	tracedTargets := make(Targets, len(*dashboard.targets))
+	copy(tracedTargets, *dashboard.targets)
+	targetsMutex.Unlock()
+
+	dashboard.mutex.Lock()
+	defer dashboard.mutex.Unlock()
+
+	statuses := []targetStatus{}
+	traced := map[int]bool{}
+	for _, target := range tracedTargets {
+		traced[target.Id] = true
+		p, found := dashboard.presences[target.Id]
+		if !found {
+			p = presence{Id: target.Id, LastSeenTime: target.LastSeenTime}
+		}
+		statuses = append(statuses, targetStatus{target: target, presence: p})
+	}
+	for id := range dashboard.presences {
+		if !traced[id] {
+			delete(dashboard.presences, id)
+		}
+	}
+
+	spotted := []targetStatus{}
+	for id, spottedTarget := range dashboard.spotted {
+		if traced[id] || now.Sub(spottedTarget.time) > dashboardSpottedTtl {
+			delete(dashboard.spotted, id)
+			continue
+		}
+		spotted = append(spotted, targetStatus{spottedTarget.target, spottedTarget.presence, spottedTarget.time})
+	}
+	sort.Slice(spotted, func(i, j int) bool {
+		return spotted[i].spottedTime.After(spotted[j].spottedTime)
+	})
+
+	return append(statuses, spotted...)
+}
+
+func (dashboard *Dashboard) render() string {
+	now := dashboard.clock.Now()
+	statuses := dashboard.statuses()
+
+	text := new(strings.Builder)
+	text.WriteString("📊 Tracing list")
+	if len(statuses) == 0 || !statuses[0].spottedTime.IsZero() {
+		text.WriteString(" is empty\n")
+	} else {
+		text.WriteString("\n\n")
+	}
+	spottedHeader := false
+	for _, status := range statuses {
+		if !status.spottedTime.IsZero() && !spottedHeader {
+			text.WriteString("\n👀 Spotted\n\n")
+			spottedHeader = true
+		}
+		text.WriteString(status.line(now) + "\n")
+	}
+
+	text.WriteString("\n🕒 Updated " + now.Format("15:04"))
+	return text.String()
+}
+
+// title is status and name of target, like "⚪ durov (Pavel Durov)"
+func (status targetStatus) title() string {
+	name := strconv.Itoa(status.target.Id)
+	if status.target.DomainIsPrimary {
+		name = status.target.Domain
+	}
+	title := fmt.Sprintf("%s (%s %s)", name, status.target.FirstName, status.target.LastName)
+	if status.presence.Online {
+		return "🟢 " + title
+	}
+	return "⚪ " + title
+}
+
+// details are last seen time and platform, like "last seen 12 min ago, 📱 Android"
+func (status targetStatus) details(now time.Time) string {
+	p := status.presence
+	var details string
+	if p.Online {
+		details = "online"
+	} else if p.LastSeenTime != 0 {
+		details = "last seen " + formatAgo(now.Sub(time.Unix(int64(p.LastSeenTime), 0)))
+	} else {
+		details = "offline"
+	}
+	if platform, found := vkPlatforms[p.Platform]; found {
+		details += ", " + platform
+	}
+	if !status.spottedTime.IsZero() {
+		details += ", spotted " + formatAgo(now.Sub(status.spottedTime))
+	}
+	return details
+}
+
+func (status targetStatus) line(now time.Time) string {
+	return status.title() + " — " + status.details(now)
+}
+
+func formatAgo(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return fmt.Sprintf("%d min ago", int(d/time.Minute))
+	case d < time.Hour*24:
+		return fmt.Sprintf("%d h ago", int(d/time.Hour))
+	default:
+		return fmt.Sprintf("%d d ago", int(d/(time.Hour*24)))
+	}
+}
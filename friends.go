@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+const friendsRefreshInterval = time.Minute * 10
+
+// Friends is a cached list of the token owner's friends. Presence of friends
+// can be fetched cheaper than with users.get - via Long Poll or friends.getOnline
+type Friends struct {
+	vk            VKClient
+	clock         Clock
+	ids           map[int]bool
+	refreshedTime time.Time
+	mutex         sync.RWMutex
+}
+
+func NewFriends(vk VKClient, clock Clock) *Friends {
+	return &Friends{
+		vk:    vk,
+		clock: clock,
+		ids:   map[int]bool{},
+	}
+}
+
+func (friends *Friends) contains(id int) bool {
+	friends.mutex.RLock()
+	defer friends.mutex.RUnlock()
+	return friends.ids[id]
+}
+
+func (friends *Friends) refresh(ctx context.Context) error {
+	friendIds, err := friends.vk.GetFriends(ctx)
+	if err != nil {
+		return err
+	}
+	ids := make(map[int]bool, len(friendIds))
+	for _, id := range friendIds {
+		ids[id] = true
+	}
+	friends.mutex.Lock()
+	friends.ids = ids
+	friends.refreshedTime = friends.clock.Now()
+	friends.mutex.Unlock()
+	return nil
+}
+
+func (friends *Friends) refreshIfStale(ctx context.Context) {
+	friends.mutex.RLock()
+	stale := friends.clock.Now().Sub(friends.refreshedTime) > friendsRefreshInterval
+	friends.mutex.RUnlock()
+	if !stale {
+		return
+	}
+	err := friends.refresh(ctx)
+	if err != nil {
+		// Not to retry on every tick
+		friends.mutex.Lock()
+		friends.refreshedTime = friends.clock.Now()
+		friends.mutex.Unlock()
+		log.Println(err.Error())
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	longPollVersion = 3
+	longPollWait    = 25
+	// Mode 64 adds the platform to "friend online" events
+	longPollMode = 64
+
+	longPollEventFriendOnline  = 8
+	longPollEventFriendOffline = 9
+
+	// Failed requests are retried with exponential backoff
+	longPollMinBackoff = time.Second * 5
+	longPollMaxBackoff = time.Minute * 5
+)
+
+// VK errors which mean the token can't use Long Poll at all, e.g. when it
+// lacks messages scope
+const (
+	vkErrorPermissionDenied = 7
+	vkErrorAccessDenied     = 15
+)
+
+func isPermanentLongPollError(err error) bool {
+	var vkErr *vkError
+	return errors.As(err, &vkErr) && (vkErr.Code == vkErrorPermissionDenied || vkErr.Code == vkErrorAccessDenied)
+}
+
+// Updates are arrays of numbers and strings, like message text of new
+// message events, which are decoded as json.Number and string
+type longPollResponse struct {
+	Ts      vkInt           `json:"ts"`
+	Failed  int             `json:"failed"`
+	Updates [][]interface{} `json:"updates"`
+}
+
+// longPollInt returns number of update's field, or 0 if it's not a number
+func longPollInt(value interface{}) int64 {
+	number, _ := value.(json.Number)
+	n, _ := number.Int64()
+	return n
+}
+
+// LongPoll receives friends' presence from VK's messages Long Poll server.
+// Targets which are friends of the token owner are covered by it while it's
+// healthy, everyone else is left to users.get polling in startTracing
+type LongPoll struct {
+	client  *http.Client
+	vk      VKClient
+	friends *Friends
+	clock   Clock
+	server  *vkLongPollServer
+	healthy bool
+	mutex   sync.RWMutex
+}
+
+func NewLongPoll(vk VKClient, friends *Friends, clock Clock) *LongPoll {
+	return &LongPoll{
+		client:  &http.Client{Timeout: time.Second * (longPollWait + 10)},
+		vk:      vk,
+		friends: friends,
+		clock:   clock,
+	}
+}
+
+func (longPoll *LongPoll) covers(id int) bool {
+	longPoll.mutex.RLock()
+	healthy := longPoll.healthy
+	longPoll.mutex.RUnlock()
+	return healthy && longPoll.friends.contains(id)
+}
+
+func (longPoll *LongPoll) setHealthy(healthy bool) {
+	longPoll.mutex.Lock()
+	longPoll.healthy = healthy
+	longPoll.mutex.Unlock()
+}
+
+func (longPoll *LongPoll) refreshServer(ctx context.Context) error {
+	server, err := longPoll.vk.GetLongPollServer(ctx)
+	if err != nil {
+		return err
+	}
+	longPoll.server = server
+	return nil
+}
+
+func (longPoll *LongPoll) check(ctx context.Context) (*longPollResponse, error) {
+	server := longPoll.server.Server
+	if !strings.Contains(server, "://") {
+		server = "https://" + server
+	}
+
+	params := url.Values{}
+	params.Set("act", "a_check")
+	params.Set("key", longPoll.server.Key)
+	params.Set("ts", strconv.Itoa(int(longPoll.server.Ts)))
+	params.Set("wait", strconv.Itoa(longPollWait))
+	params.Set("mode", strconv.Itoa(longPollMode))
+	params.Set("version", strconv.Itoa(longPollVersion))
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, server+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := longPoll.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("long poll server responded with %s", response.Status)
+	}
+
+	longPollResponse := new(longPollResponse)
+	decoder := json.NewDecoder(response.Body)
+	decoder.UseNumber()
+	err = decoder.Decode(longPollResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	return longPollResponse, nil
+}
+
+// poll makes one a_check request and returns presence observed in it
+func (longPoll *LongPoll) poll(ctx context.Context) ([]presence, error) {
+	if longPoll.server == nil {
+		err := longPoll.refreshServer(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	longPoll.friends.refreshIfStale(ctx)
+
+	response, err := longPoll.check(ctx)
+	if err != nil {
+		longPoll.server = nil
+		return nil, err
+	}
+
+	switch response.Failed {
+	case 0:
+	case 1:
+		// History is outdated, continue from the ts given
+		longPoll.server.Ts = response.Ts
+		return nil, nil
+	case 2, 3:
+		// Key is expired or user information is lost, a new server is needed
+		longPoll.server = nil
+		return nil, nil
+	default:
+		longPoll.server = nil
+		return nil, fmt.Errorf("long poll failed with code %d", response.Failed)
+	}
+
+	longPoll.server.Ts = response.Ts
+
+	observed := []presence{}
+	for _, update := range response.Updates {
+		if len(update) < 4 {
+			continue
+		}
+		eventType := longPollInt(update[0])
+		if eventType != longPollEventFriendOnline && eventType != longPollEventFriendOffline {
+			continue
+		}
+		userId := longPollInt(update[1])
+		extra := longPollInt(update[2])
+		timestamp := longPollInt(update[3])
+		if userId < 0 {
+			userId = -userId
+		}
+
+		p := presence{
+			Id:           int(userId),
+			LastSeenTime: int(timestamp),
+		}
+		if eventType == longPollEventFriendOnline {
+			p.Online = true
+			p.Platform = int(extra & 0xFF)
+		}
+		observed = append(observed, p)
+	}
+
+	return observed, nil
+}
+
+// start receives presence until ctx is done, or until it turns out Long Poll
+// isn't available with the token, then friends are left to polling
+func (longPoll *LongPoll) start(ctx context.Context, observe func(p presence)) {
+	backoff := longPollMinBackoff
+	for ctx.Err() == nil {
+		observed, err := longPoll.poll(ctx)
+		if err != nil {
+			longPoll.setHealthy(false)
+			if ctx.Err() != nil {
+				return
+			}
+			if isPermanentLongPollError(err) {
+				log.Println("Long Poll is unavailable, friends are polled instead:", err.Error())
+				return
+			}
+			log.Println(err.Error())
+			select {
+			case <-ctx.Done():
+				return
+			case <-longPoll.clock.After(backoff):
+			}
+			backoff *= 2
+			if backoff > longPollMaxBackoff {
+				backoff = longPollMaxBackoff
+			}
+			continue
+		}
+		backoff = longPollMinBackoff
+		longPoll.setHealthy(longPoll.server != nil)
+		for _, p := range observed {
+			observe(p)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeLongPollServer responds to every a_check with the next of responses
+// and remembers query of every request
+type fakeLongPollServer struct {
+	*httptest.Server
+	responses []string
+	requests  []map[string]string
+	mutex     sync.Mutex
+}
+
+func newFakeLongPollServer(t *testing.T, responses ...string) *fakeLongPollServer {
+	server := &fakeLongPollServer{responses: responses}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.mutex.Lock()
+		defer server.mutex.Unlock()
+		query := r.URL.Query()
+		server.requests = append(server.requests, map[string]string{
+			"act": query.Get("act"),
+			"key": query.Get("key"),
+			"ts":  query.Get("ts"),
+		})
+		if len(server.responses) == 0 {
+			t.Errorf("unexpected long poll request %s", r.URL)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, server.responses[0])
+		server.responses = server.responses[1:]
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func (server *fakeLongPollServer) Requests() []map[string]string {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return server.requests
+}
+
+func newTestLongPoll(serverUrl string) (*LongPoll, *FakeVKClient) {
+	vk := NewFakeVKClient()
+	vk.SetLongPollServer(&vkLongPollServer{Key: "key", Server: serverUrl, Ts: 10})
+	clock := NewFakeClock(time.Unix(1600000000, 0))
+	return NewLongPoll(vk, NewFriends(vk, clock), clock), vk
+}
+
+func TestLongPollPoll(t *testing.T) {
+	tests := []struct {
+		name         string
+		response     string
+		wantObserved []presence
+		wantErr      bool
+		// wantTs is ts of the next request, 0 if a new server is needed
+		wantTs vkInt
+	}{
+		{
+			name:         "no updates",
+			response:     `{"ts": 11, "updates": []}`,
+			wantObserved: []presence{},
+			wantTs:       11,
+		},
+		{
+			name: "friend online and offline",
+			response: `{"ts": "12", "updates": [
+				[8, -1, 4, 1600000100],
+				[9, -2, 0, 1600000200],
+				[4, 100, 1, 2000000001, 1600000300, "text"]
+			]}`,
+			wantObserved: []presence{
+				{Id: 1, Online: true, Platform: 4, LastSeenTime: 1600000100},
+				{Id: 2, LastSeenTime: 1600000200},
+			},
+			wantTs: 12,
+		},
+		{
+			name:     "history outdated",
+			response: `{"failed": 1, "ts": 30}`,
+			wantTs:   30,
+		},
+		{
+			name:     "key expired",
+			response: `{"failed": 2}`,
+		},
+		{
+			name:     "user information lost",
+			response: `{"failed": 3}`,
+		},
+		{
+			name:     "unknown failure",
+			response: `{"failed": 4}`,
+			wantErr:  true,
+		},
+		{
+			name:     "invalid response",
+			response: `not json`,
+			wantErr:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newFakeLongPollServer(t, test.response)
+			longPoll, _ := newTestLongPoll(server.URL)
+
+			observed, err := longPoll.poll(context.Background())
+			if (err != nil) != test.wantErr {
+				t.Fatalf("poll() error = %v, wantErr %v", err, test.wantErr)
+			}
+			if !reflect.DeepEqual(observed, test.wantObserved) {
+				t.Errorf("poll() = %+v, want %+v", observed, test.wantObserved)
+			}
+
+			wantRequest := map[string]string{"act": "a_check", "key": "key", "ts": "10"}
+			if requests := server.Requests(); !reflect.DeepEqual(requests[0], wantRequest) {
+				t.Errorf("request = %v, want %v", requests[0], wantRequest)
+			}
+
+			var ts vkInt
+			if longPoll.server != nil {
+				ts = longPoll.server.Ts
+			}
+			if ts != test.wantTs {
+				t.Errorf("ts = %d, want %d", ts, test.wantTs)
+			}
+		})
+	}
+}
+
+func TestLongPollServerRefresh(t *testing.T) {
+	server := newFakeLongPollServer(t,
+		`{"ts": 11, "updates": []}`,
+		`{"failed": 1, "ts": 20}`,
+		`{"failed": 2}`,
+		`{"ts": 21, "updates": [[8, -1, 7, 1600000100]]}`,
+	)
+	longPoll, vk := newTestLongPoll(server.URL)
+
+	for i := 0; i < 4; i++ {
+		_, err := longPoll.poll(context.Background())
+		if err != nil {
+			t.Fatalf("poll() error = %v", err)
+		}
+	}
+
+	// Server is requested again after the key expired, and a_check starts
+	// over from its ts
+	wantTs := []string{"10", "11", "20", "10"}
+	for i, request := range server.Requests() {
+		if request["ts"] != wantTs[i] {
+			t.Errorf("request %d ts = %s, want %s", i, request["ts"], wantTs[i])
+		}
+	}
+	if calls := vk.LongPollServerCalls(); calls != 2 {
+		t.Errorf("GetLongPollServer called %d times, want 2", calls)
+	}
+}
+
+func TestLongPollStartBacksOff(t *testing.T) {
+	// Fake VK has no Long Poll server, so every poll fails
+	vk := NewFakeVKClient()
+	clock := NewFakeClock(time.Unix(1600000000, 0))
+	longPoll := NewLongPoll(vk, NewFriends(vk, clock), clock)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		longPoll.start(ctx, func(p presence) {})
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// Retries come after 5s, 10s and 20s
+	steps := []struct {
+		advance   time.Duration
+		wantCalls int
+	}{
+		{0, 1},
+		{time.Second * 5, 2},
+		{time.Second * 5, 2},
+		{time.Second * 5, 3},
+		{time.Second * 15, 3},
+		{time.Second * 5, 4},
+	}
+	for i, step := range steps {
+		clock.Advance(step.advance)
+		clock.BlockUntilWaiters(1)
+		if calls := vk.LongPollServerCalls(); calls != step.wantCalls {
+			t.Fatalf("step %d: GetLongPollServer called %d times, want %d", i, calls, step.wantCalls)
+		}
+	}
+}
+
+func TestLongPollStartStopsOnPermanentError(t *testing.T) {
+	vk := NewFakeVKClient()
+	vk.Err = &vkError{Code: vkErrorAccessDenied, Message: "no access to call this method"}
+	clock := NewFakeClock(time.Unix(1600000000, 0))
+	longPoll := NewLongPoll(vk, NewFriends(vk, clock), clock)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		longPoll.start(context.Background(), func(p presence) {})
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("start() is still retrying after permanent error")
+	}
+	if calls := vk.LongPollServerCalls(); calls != 1 {
+		t.Errorf("GetLongPollServer called %d times, want 1", calls)
+	}
+}
@@ -1,32 +1,66 @@
 package main
 
 import (
-	"encoding/json"
-	"errors"
+	"context"
+	"flag"
 	"fmt"
 	"log"
-	"net/http"
-	"net/url"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"./telegram"
 )
 
 type Target struct {
-	Id              int
-	Domain          string
-	DomainIsPrimary bool
-	FirstName       string
-	LastName        string
-	LastSeenTime    int
+	Id              int    `json:"id"`
+	Domain          string `json:"domain"`
+	DomainIsPrimary bool   `json:"domain_is_primary"`
+	FirstName       string `json:"first_name"`
+	LastName        string `json:"last_name"`
+	LastSeenTime    int    `json:"last_seen_time"`
 }
 
 type Targets []*Target
 
+func (target *Target) templateData() templateData {
+	name := strconv.Itoa(target.Id)
+	if target.DomainIsPrimary {
+		name = target.Domain
+	}
+	return templateData{
+		Id:         target.Id,
+		Domain:     telegram.EscapeHTML(target.Domain),
+		Name:       telegram.EscapeHTML(name),
+		FirstName:  telegram.EscapeHTML(target.FirstName),
+		LastName:   telegram.EscapeHTML(target.LastName),
+		ProfileUrl: telegram.EscapeHTML(target.profileUrl()),
+	}
+}
+
+// profileUrl is link to target's VK page, users without custom domain have
+// domain like id1
+func (target *Target) profileUrl() string {
+	if target.Domain == "" {
+		return "https://vk.com/id" + strconv.Itoa(target.Id)
+	}
+	return "https://vk.com/" + target.Domain
+}
+
+// matches reports if query is target's id, domain or a part of target's name,
+// query must be lowercase
+func (target *Target) matches(query string) bool {
+	if query == strconv.Itoa(target.Id) || query == strings.ToLower(target.Domain) {
+		return true
+	}
+	name := strings.ToLower(target.FirstName + " " + target.LastName)
+	return strings.Contains(name, query) || strings.Contains(strings.ToLower(target.Domain), query)
+}
+
 func (targets *Targets) find(id int) *Target {
 	for _, target := range *targets {
 		if target.Id == id {
@@ -56,383 +90,231 @@ func (targets *Targets) clear() {
 
 var targets Targets
 
-func (targets *Targets) startTracing(bot *telegram.Bot) {
-	for {
-		time.Sleep(time.Second * 7)
-		userIdsToGet := []string{}
-		for _, target := range *targets {
-			userIdsToGet = append(userIdsToGet, strconv.Itoa(target.Id))
+// inlineQueryResultsLimit is the most results Telegram accepts in one answer
+const inlineQueryResultsLimit = 50
+
+// handleInlineQuery answers with status cards of targets matching the query,
+// or of all targets if it's empty
+func handleInlineQuery(ctx context.Context, bot *telegram.Bot, dashboard *Dashboard, inlineQuery *telegram.InlineQuery) {
+	now := dashboard.clock.Now()
+	query := strings.ToLower(strings.TrimSpace(inlineQuery.Query))
+
+	results := []telegram.InlineQueryResult{}
+	for _, status := range dashboard.statuses() {
+		if len(results) == inlineQueryResultsLimit {
+			break
 		}
-		users, err := vkGetUsers(userIdsToGet)
-		if err != nil {
-			log.Println(err.Error())
+		if query != "" && !status.target.matches(query) {
 			continue
 		}
-		for _, user := range users {
-			for _, target := range *targets {
-				if user.Id == target.Id {
-					if user.Online == 1 || user.LastSeen.Time != target.LastSeenTime {
-						targets.remove(target.Id)
-
-						var notificationMessageText string
-						if target.DomainIsPrimary {
-							notificationMessageText = fmt.Sprintf("✉️ %s (%s %s) Online", target.Domain, target.FirstName, target.LastName)
-						} else {
-							notificationMessageText = fmt.Sprintf("✉️ %d (%s %s) Online", target.Id, target.FirstName, target.LastName)
-						}
-						var domainIsPrimaryCallbackArg string
-						if target.DomainIsPrimary {
-							domainIsPrimaryCallbackArg = "true"
-						} else {
-							domainIsPrimaryCallbackArg = "false"
-						}
-						bot.SendMessage(OWNER_ID, notificationMessageText, &telegram.SendMessageConfig{
-							ReplyMarkup: &telegram.ReplyMarkup{
-								InlineKeyboardMarkup: &telegram.InlineKeyboardMarkup{
-									InlineKeyboard: telegram.InlineKeyboard{
-										telegram.InlineKeyboardRow{
-											telegram.InlineKeyboardButton{
-												Text: "🔄 Repeat", CallbackData: fmt.Sprintf("repeat:%d:%s", target.Id, domainIsPrimaryCallbackArg),
-											},
-										},
-									},
-								},
-							},
-						})
-					}
-				}
-			}
-		}
+		results = append(results, telegram.InlineQueryResultArticle{
+			Id:          strconv.Itoa(status.target.Id),
+			Title:       status.title(),
+			Description: status.details(now),
+			InputMessageContent: telegram.InputTextMessageContent{
+				MessageText: status.line(now),
+			},
+		})
 	}
-}
-
-var VK_TOKEN, TG_TOKEN, OWNER_ID = "", "", 0
 
-type vkUser struct {
-	Id              int    `json:"id"`
-	FirstName       string `json:"first_name"`
-	LastName        string `json:"last_name"`
-	IsClosed        bool   `json:"is_closed"`
-	CanAccessClosed bool   `json:"can_access_closed"`
-	Domain          string `json:"domain"`
-	Online          int    `json:"online"`
-	LastSeen        struct {
-		Platfrom int `json:"platform"`
-		Time     int `json:"time"`
-	} `json:"last_seen"`
+	// Statuses change all the time and tracing list is only owners' business,
+	// so results are neither cached nor shared between users
+	_, err := bot.AnswerInlineQueryContext(ctx, inlineQuery.Id, results, &telegram.AnswerInlineQueryConfig{
+		CacheTime:  0,
+		IsPersonal: true,
+	})
+	if err != nil {
+		log.Println(err.Error())
+	}
 }
 
-func vkGetUsers(userIds []string) ([]vkUser, error) {
-	params := url.Values{}
-	params.Set("access_token", VK_TOKEN)
-	params.Set("v", "5.126")
-	params.Set("lang", "ru")
-	params.Set("user_ids", strings.Join(userIds, ","))
-	params.Set("fields", "last_seen,online,domain")
+func main() {
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "path to TOML config file")
+	flag.Parse()
 
-	url := "https://api.vk.com/method/users.get?" + params.Encode()
-	response, err := http.Get(url)
+	config, err := loadConfig(*configPath)
 	if err != nil {
-		return nil, err
-	}
-
-	var responseStruct struct {
-		Response *[]vkUser `json:"response"`
+		fmt.Println(err.Error())
+		return
 	}
+	setConfig(config)
 
-	decoder := json.NewDecoder(response.Body)
-	decoder.Decode(&responseStruct)
-
-	if responseStruct.Response == nil {
-		return nil, errors.New("users.get method error")
+	state, err := loadState(config.StateFile)
+	if err != nil {
+		fmt.Println("Can't load state:", err.Error())
+		return
 	}
-
-	return *responseStruct.Response, nil
-}
-
-func handleMessage(bot *telegram.Bot, message *telegram.Message) {
-	splittedMessage := strings.Split(message.Text, " ")
-	command := splittedMessage[0]
-	var args []string
-	if len(splittedMessage) > 1 {
-		args = splittedMessage[1:]
+	targets = state.Targets
+	if state.QuietHours != "" {
+		err = setQuietHoursOverride(state.QuietHours)
+		if err != nil {
+			log.Println("Can't apply saved quiet hours:", err.Error())
+		}
 	}
 
-	if command == "/start" {
-		bot.SendMessage(OWNER_ID, "👋 Hello", &telegram.SendMessageConfig{
-			ReplyMarkup: &telegram.ReplyMarkup{
-				ReplyKeyboardMarkup: &telegram.ReplyKeyboardMarkup{
-					Keyboard: telegram.ReplyKeyboard{
-						telegram.ReplyKeyboardRow{
-							telegram.ReplyKeyboardButton{Text: "📝 List"},
-						},
-						telegram.ReplyKeyboardRow{
-							telegram.ReplyKeyboardButton{Text: "♻️ Clear List"},
-						},
-					},
-					ResizeKeyboard: true,
-				},
-			},
-		})
-	} else if command == "/add" {
-		if len(args) == 0 {
-			bot.SendMessage(OWNER_ID, "ℹ️ No arguments", nil)
-			return
-		}
+	bot := telegram.NewBot(config.TelegramToken)
+	callbackCodec = telegram.NewCallbackCodec(callbackSecret(config.TelegramToken))
 
-		userIdsToGet := []string{}
-		for _, vkIdOrDomain := range args {
-			found := false
-			for _, userIdToGet := range userIdsToGet {
-				if userIdToGet == vkIdOrDomain {
-					found = true
-					break
-				}
-			}
-			if found {
-				continue
-			}
-			if vkIdOrDomain != "" {
-				userIdsToGet = append(userIdsToGet, vkIdOrDomain)
-			}
-		}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-		users, err := vkGetUsers(userIdsToGet)
-		if err != nil {
-			log.Println(err.Error())
-			return
+	reloadSignals := make(chan os.Signal, 1)
+	signal.Notify(reloadSignals, syscall.SIGHUP)
+	go func() {
+		for range reloadSignals {
+			reloadConfig(*configPath)
 		}
+	}()
 
-		sendingMessages := sync.WaitGroup{}
-		sendingMessages.Add(len(userIdsToGet))
-
-		for _, user := range users {
-			var domainIsPrimary bool
-			for i, id := range userIdsToGet {
-				if id == user.Domain {
-					domainIsPrimary = true
-					userIdsToGet[i] = ""
-				} else if id == strconv.Itoa(user.Id) {
-					domainIsPrimary = false
-					userIdsToGet[i] = ""
-				}
-			}
+	// Handlers and notifications aren't cancelled with ctx so they can finish
+	// within shutdownTimeout
+	handlersCtx, cancelHandlers := context.WithCancel(context.Background())
+	defer cancelHandlers()
 
-			if targets.find(user.Id) != nil {
-				var replyText string
-				if domainIsPrimary {
-					replyText = fmt.Sprintf("ℹ️ %s (%s %s) Already added", user.Domain, user.FirstName, user.LastName)
-				} else {
-					replyText = fmt.Sprintf("ℹ️ %d (%s %s) Already added", user.Id, user.FirstName, user.LastName)
-				}
-				go func() {
-					bot.SendMessage(OWNER_ID, replyText, nil)
-					sendingMessages.Done()
-				}()
-				continue
-			}
+	if config.MetricsAddr != "" {
+		go serveMetrics(ctx, config.MetricsAddr, &targets)
+	}
 
-			if user.Online == 1 {
-				var replyText string
-				if domainIsPrimary {
-					replyText = fmt.Sprintf("✉️ %s (%s %s) Online", user.Domain, user.FirstName, user.LastName)
-				} else {
-					replyText = fmt.Sprintf("✉️ %d (%s %s) Online", user.Id, user.FirstName, user.LastName)
-				}
-				go func() {
-					bot.SendMessage(OWNER_ID, replyText, nil)
-					sendingMessages.Done()
-				}()
-				continue
-			}
+	vk := NewVKClient(config.VKToken, config.VKApiUrl, config.VKApiVersion, config.VKLang)
+	dashboard := NewDashboard(bot, &targets, realClock{}, state.DashboardMessages)
+	go dashboard.start(ctx)
+	tracker := NewTracker(&targets, vk, realClock{}, dashboard)
+	tracing := make(chan struct{})
+	go func() {
+		defer close(tracing)
+		tracker.startTracing(ctx, handlersCtx, bot)
+	}()
+
+	router := NewSpotter(vk, dashboard).router()
+	err = publishCommands(ctx, bot, router)
+	if err != nil {
+		log.Println("Can't publish commands:", err.Error())
+	}
 
-			targets.add(&Target{
-				Id:              user.Id,
-				Domain:          user.Domain,
-				DomainIsPrimary: domainIsPrimary,
-				FirstName:       user.FirstName,
-				LastName:        user.LastName,
-				LastSeenTime:    user.LastSeen.Time,
-			})
-
-			var replyText string
-			if domainIsPrimary {
-				replyText = fmt.Sprintf("✅ %s (%s %s) Added", user.Domain, user.FirstName, user.LastName)
-			} else {
-				replyText = fmt.Sprintf("✅ %d (%s %s) Added", user.Id, user.FirstName, user.LastName)
-			}
-			go func() {
-				bot.SendMessage(OWNER_ID, replyText, nil)
-				sendingMessages.Done()
-			}()
-		}
-		for _, id := range userIdsToGet {
-			if id != "" {
-				replyText := fmt.Sprintf("❌ %s Not found", id)
-				go func() {
-					bot.SendMessage(OWNER_ID, replyText, nil)
-					sendingMessages.Done()
-				}()
+	updates := make(chan telegram.Update)
+	// Offset is tracked only with getUpdates, updates received with webhook
+	// are confirmed by responding to them
+	var updatesTracker *telegram.UpdatesTracker
+	if config.UpdatesMode == updatesModeWebhook {
+		go func() {
+			err := serveWebhook(ctx, bot, config, updates)
+			if err != nil {
+				log.Println("Webhook failed:", err.Error())
+				stop()
 			}
+		}()
+	} else {
+		// getUpdates doesn't work while webhook is set
+		_, err = bot.DeleteWebhookContext(ctx, false)
+		if err != nil {
+			log.Println("Can't delete webhook:", err.Error())
 		}
+		updatesTracker = telegram.NewUpdatesTracker(state.UpdateOffset)
+		go bot.GrabUpdatesToChan(ctx, updates, &telegram.GrabUpdatesConfig{
+			AllowedUpdates: allowedUpdates,
+			Tracker:        updatesTracker,
+			OnError: func(err error) {
+				metrics.observeTelegramUpdatesError(err)
+				log.Println(err.Error())
+			},
+		})
+	}
 
-		sendingMessages.Wait()
-	} else if command == "/remove" {
-		if len(args) == 0 {
-			bot.SendMessage(OWNER_ID, "ℹ️ No arguments", nil)
-			return
+	stateMutex := sync.Mutex{}
+	persistState := func() {
+		stateMutex.Lock()
+		defer stateMutex.Unlock()
+		state := &State{
+			DashboardMessages: dashboard.messageIds(),
+			QuietHours:        getQuietHoursOverride(),
 		}
-
-		for _, vkIdOrDomain := range args {
-			found := false
-			for _, target := range targets {
-				if target.Domain == vkIdOrDomain || strconv.Itoa(target.Id) == vkIdOrDomain {
-					found = true
-					targets.remove(target.Id)
-					var replyText string
-					if target.DomainIsPrimary {
-						replyText = fmt.Sprintf("✅ %s (%s %s) Removed", target.Domain, target.FirstName, target.LastName)
-					} else {
-						replyText = fmt.Sprintf("✅ %d (%s %s) Removed", target.Id, target.FirstName, target.LastName)
-					}
-					bot.SendMessage(OWNER_ID, replyText, nil)
-					break
-				}
-			}
-			if !found {
-				bot.SendMessage(OWNER_ID, fmt.Sprintf("❌ %s Not found in tracing list", vkIdOrDomain), nil)
-			}
+		if updatesTracker != nil {
+			state.UpdateOffset = updatesTracker.Offset()
 		}
-	} else if command == "/clear" || command == "♻️" {
-		if len(targets) == 0 {
-			bot.SendMessage(OWNER_ID, fmt.Sprintf("ℹ️ Tracing list is empty"), nil)
-			return
+		targetsMutex.Lock()
+		state.Targets = targets
+		err := saveState(config.StateFile, state)
+		targetsMutex.Unlock()
+		if err != nil {
+			log.Println("Can't save state:", err.Error())
 		}
+	}
 
-		targets.clear()
-		bot.SendMessage(OWNER_ID, "✅ Tracing list cleared", nil)
-	} else if command == "/list" || command == "📝" {
-		replyText := "📝 Tracing list"
-		if len(targets) == 0 {
-			replyText += " is empty"
-		} else {
-			replyText += "\n\n"
-		}
-		for i, target := range targets {
-			if target.DomainIsPrimary {
-				replyText += fmt.Sprintf("%d. %s (%s %s)\n", i+1, target.Domain, target.FirstName, target.LastName)
-			} else {
-				replyText += fmt.Sprintf("%d. %d (%s %s)\n", i+1, target.Id, target.FirstName, target.LastName)
+	dispatcher := telegram.NewDispatcher(updateWorkers, func(update telegram.Update) {
+		// Update is done even if its handler panicked, otherwise offset would
+		// be stuck at it. Update interrupted by shutdown is received again
+		// after restart
+		defer func() {
+			if handlersCtx.Err() != nil {
+				return
+			}
+			if updatesTracker != nil {
+				updatesTracker.Done(update.UpdateId)
 			}
+			persistState()
+		}()
+		handleUpdate(handlersCtx, bot, router, dashboard, update)
+	})
+	for ctx.Err() == nil {
+		select {
+		case <-ctx.Done():
+		case update := <-updates:
+			dispatcher.Dispatch(update)
 		}
-		bot.SendMessage(OWNER_ID, replyText, nil)
-	} else {
-		bot.SendMessage(OWNER_ID, "ℹ️ Unknown command", nil)
-	}
-}
-
-func handleCallback(bot *telegram.Bot, callback *telegram.CallbackQuery) {
-	splittedCallbackData := strings.Split(callback.Data, ":")
-	command := splittedCallbackData[0]
-	var args []string
-	if len(splittedCallbackData) > 1 {
-		args = splittedCallbackData[1:]
 	}
 
-	if command == "repeat" {
-		if len(args) != 2 {
-			bot.AnswerCallbackQuery(callback.Id, "❌ Error occurred", false)
-			return
-		}
-
-		var domainIsPrimary bool
-		if args[1] == "true" {
-			domainIsPrimary = true
-		} else {
-			domainIsPrimary = false
-		}
+	log.Println("Shutting down")
+	shutdown(dispatcher, tracing, cancelHandlers)
+	persistState()
 
-		users, err := vkGetUsers(args[0:1])
+	// Offset is saved in state anyway, it's confirmed in case state is lost
+	if updatesTracker != nil && updatesTracker.Offset() != 0 {
+		err = bot.ConfirmUpdates(updatesTracker.Offset())
 		if err != nil {
-			bot.AnswerCallbackQuery(callback.Id, "❌ Error occurred", false)
-			return
+			log.Println("Can't confirm updates:", err.Error())
 		}
+	}
+}
 
-		if len(users) != 1 {
-			bot.AnswerCallbackQuery(callback.Id, "❌ Error occurred", false)
-			return
-		}
+// updateWorkers is how many chats' updates are handled at once
+const updateWorkers = 8
 
-		user := users[0]
+// allowedUpdates are kinds of updates handled by handleUpdate, others aren't
+// even received
+var allowedUpdates = []string{"message", "callback_query", "inline_query"}
 
-		if user.Online == 1 {
-			bot.AnswerCallbackQuery(callback.Id, "ℹ️ User is online", false)
-			return
+func handleUpdate(ctx context.Context, bot *telegram.Bot, router *telegram.Router, dashboard *Dashboard, update telegram.Update) {
+	if update.Message != nil {
+		router.HandleMessage(ctx, bot, update.Message)
+	} else if update.InlineQuery != nil {
+		if getConfig().isOwner(update.InlineQuery.From.Id) {
+			handleInlineQuery(ctx, bot, dashboard, update.InlineQuery)
 		}
-
-		targets.add(&Target{
-			Id:              user.Id,
-			Domain:          user.Domain,
-			DomainIsPrimary: domainIsPrimary,
-			FirstName:       user.FirstName,
-			LastName:        user.LastName,
-			LastSeenTime:    user.LastSeen.Time,
-		})
-
-		bot.AnswerCallbackQuery(callback.Id, "✅ User added again", false)
-		bot.EditMessageReplyMarkup(callback.Message.Chat.Id, callback.Message.MessageId, &telegram.ReplyMarkup{
-			InlineKeyboardMarkup: &telegram.InlineKeyboardMarkup{
-				InlineKeyboard: telegram.InlineKeyboard{
-					telegram.InlineKeyboardRow{
-						telegram.InlineKeyboardButton{
-							Text: "ℹ️ Repeated", CallbackData: "plug",
-						},
-					},
-				},
-			},
-		})
-	} else {
-		bot.AnswerCallbackQuery(callback.Id, "", false)
+	} else if update.CallbackQuery != nil {
+		router.HandleCallbackQuery(ctx, bot, update.CallbackQuery)
 	}
 }
 
-func main() {
-	TG_TOKEN = os.Getenv("TG_TOKEN")
-	VK_TOKEN = os.Getenv("VK_TOKEN")
-	ownerIdString := os.Getenv("OWNER_ID")
-	if TG_TOKEN == "" {
-		fmt.Println("TG_TOKEN Not specified")
-		return
-	}
-	if VK_TOKEN == "" {
-		fmt.Println("VK_TOKEN Not specified")
-		return
-	}
-	if ownerIdString == "" {
-		fmt.Println("OWNER_ID Not specified")
-		return
-	}
-    var err error
-	OWNER_ID, err = strconv.Atoi(ownerIdString)
-	if err != nil {
-		fmt.Println("OWNER_ID Must be a number")
-		return
-	}
-
-	bot := telegram.NewBot(TG_TOKEN)
-
-	go targets.startTracing(bot)
-
-	updates := make(chan telegram.Update)
-	go bot.GrabUpdatesToChan(updates)
-
-	for update := range updates {
-		if update.Message != nil {
-			if update.Message.From.Id == OWNER_ID && update.Message.Chat.Type == "private" {
-				go handleMessage(bot, update.Message)
-			}
-		} else if update.CallbackQuery != nil {
-			go handleCallback(bot, update.CallbackQuery)
+const shutdownTimeout = time.Second * 10
+
+// shutdown waits for in-flight handlers and notifications, tracing is closed
+// when tracker stops. They're cancelled if they don't finish within
+// shutdownTimeout
+func shutdown(dispatcher *telegram.Dispatcher, tracing <-chan struct{}, cancelHandlers context.CancelFunc) {
+	handlersDone := make(chan struct{})
+	go func() {
+		dispatcher.Wait()
+		<-tracing
+		close(handlersDone)
+	}()
+	select {
+	case <-handlersDone:
+	case <-time.After(shutdownTimeout):
+		log.Println("Handlers didn't finish in time, cancelling them")
+		cancelHandlers()
+		select {
+		case <-handlersDone:
+		case <-time.After(time.Second):
 		}
 	}
 }
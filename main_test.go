@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+
+	"./telegram"
+)
+
+// useTestGlobals replaces config, callback codec and tracing list for the
+// test, owners are allowed to use the bot
+func useTestGlobals(t *testing.T, owners ...int) {
+	previousConfig := getConfig()
+	previousCodec := callbackCodec
+	targetsMutex.Lock()
+	previousTargets := targets
+	targets = Targets{}
+	targetsMutex.Unlock()
+
+	testConfig := defaultConfig()
+	testConfig.Owners = owners
+	setConfig(testConfig)
+	callbackCodec = telegram.NewCallbackCodec([]byte("secret"))
+
+	t.Cleanup(func() {
+		setConfig(previousConfig)
+		callbackCodec = previousCodec
+		targetsMutex.Lock()
+		targets = previousTargets
+		targetsMutex.Unlock()
+	})
+}
+
+// targetIds returns ids of targets in tracing list order
+func targetIds(targets *Targets) []int {
+	targetsMutex.Lock()
+	defer targetsMutex.Unlock()
+	ids := []int{}
+	for _, target := range *targets {
+		ids = append(ids, target.Id)
+	}
+	return ids
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Poll is considered stuck if there was no successful one for
+// healthyPollIntervals poll intervals, and at least for minHealthyPollAge
+const (
+	healthyPollIntervals = 3
+	minHealthyPollAge    = time.Minute
+)
+
+// healthyPollAge follows poll interval, which may be changed on reload
+func healthyPollAge() time.Duration {
+	age := getConfig().PollInterval * healthyPollIntervals
+	if age < minHealthyPollAge {
+		return minHealthyPollAge
+	}
+	return age
+}
+
+var tickDurationBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type vkErrorKey struct {
+	method string
+	code   string
+}
+
+// Metrics is collected in memory and exposed in Prometheus text format
+type Metrics struct {
+	tickDurationCounts    []uint64
+	tickDurationSum       float64
+	tickDurationCount     uint64
+	vkErrors              map[vkErrorKey]uint64
+	notificationsSent     uint64
+	notificationsFailed   uint64
+	telegramUpdatesErrors uint64
+	lastSuccessfulPoll    time.Time
+	mutex                 sync.Mutex
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		tickDurationCounts: make([]uint64, len(tickDurationBuckets)),
+		vkErrors:           map[vkErrorKey]uint64{},
+		lastSuccessfulPoll: time.Now(),
+	}
+}
+
+var metrics = NewMetrics()
+
+func (metrics *Metrics) observeTick(duration time.Duration, err error) {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	seconds := duration.Seconds()
+	for i, bucket := range tickDurationBuckets {
+		if seconds <= bucket {
+			metrics.tickDurationCounts[i]++
+		}
+	}
+	metrics.tickDurationSum += seconds
+	metrics.tickDurationCount++
+	if err == nil {
+		metrics.lastSuccessfulPoll = time.Now()
+	}
+}
+
+// observeVKError counts errors by VK error code, errors which didn't come
+// from VK (network, decoding) are counted with "transport" code
+func (metrics *Metrics) observeVKError(method string, err error) {
+	code := "transport"
+	var vkErr *vkError
+	if errors.As(err, &vkErr) {
+		code = strconv.Itoa(vkErr.Code)
+	}
+	metrics.mutex.Lock()
+	metrics.vkErrors[vkErrorKey{method, code}]++
+	metrics.mutex.Unlock()
+}
+
+func (metrics *Metrics) observeNotification(err error) {
+	metrics.mutex.Lock()
+	if err != nil {
+		metrics.notificationsFailed++
+	} else {
+		metrics.notificationsSent++
+	}
+	metrics.mutex.Unlock()
+}
+
+func (metrics *Metrics) observeTelegramUpdatesError(err error) {
+	metrics.mutex.Lock()
+	metrics.telegramUpdatesErrors++
+	metrics.mutex.Unlock()
+}
+
+func (metrics *Metrics) sinceLastSuccessfulPoll() time.Duration {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	return time.Since(metrics.lastSuccessfulPoll)
+}
+
+func (metrics *Metrics) write(w io.Writer, targetsCount int) {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	fmt.Fprintln(w, "# HELP spotter_poll_tick_duration_seconds Duration of one presence poll")
+	fmt.Fprintln(w, "# TYPE spotter_poll_tick_duration_seconds histogram")
+	for i, bucket := range tickDurationBuckets {
+		fmt.Fprintf(w, "spotter_poll_tick_duration_seconds_bucket{le=\"%g\"} %d\n", bucket, metrics.tickDurationCounts[i])
+	}
+	fmt.Fprintf(w, "spotter_poll_tick_duration_seconds_bucket{le=\"+Inf\"} %d\n", metrics.tickDurationCount)
+	fmt.Fprintf(w, "spotter_poll_tick_duration_seconds_sum %g\n", metrics.tickDurationSum)
+	fmt.Fprintf(w, "spotter_poll_tick_duration_seconds_count %d\n", metrics.tickDurationCount)
+
+	fmt.Fprintln(w, "# HELP spotter_vk_errors_total VK API errors by method and error code")
+	fmt.Fprintln(w, "# TYPE spotter_vk_errors_total counter")
+	vkErrorKeys := make([]vkErrorKey, 0, len(metrics.vkErrors))
+	for key := range metrics.vkErrors {
+		vkErrorKeys = append(vkErrorKeys, key)
+	}
+	sort.Slice(vkErrorKeys, func(i, j int) bool {
+		if vkErrorKeys[i].method != vkErrorKeys[j].method {
+			return vkErrorKeys[i].method < vkErrorKeys[j].method
+		}
+		return vkErrorKeys[i].code < vkErrorKeys[j].code
+	})
+	for _, key := range vkErrorKeys {
+		fmt.Fprintf(w, "spotter_vk_errors_total{method=%q,code=%q} %d\n", key.method, key.code, metrics.vkErrors[key])
+	}
+
+	fmt.Fprintln(w, "# HELP spotter_targets Targets in tracing list")
+	fmt.Fprintln(w, "# TYPE spotter_targets gauge")
+	fmt.Fprintf(w, "spotter_targets %d\n", targetsCount)
+
+	fmt.Fprintln(w, "# HELP spotter_notifications_total Online notifications by result")
+	fmt.Fprintln(w, "# TYPE spotter_notifications_total counter")
+	fmt.Fprintf(w, "spotter_notifications_total{result=\"sent\"} %d\n", metrics.notificationsSent)
+	fmt.Fprintf(w, "spotter_notifications_total{result=\"failed\"} %d\n", metrics.notificationsFailed)
+
+	fmt.Fprintln(w, "# HELP spotter_telegram_get_updates_errors_total Failed getUpdates requests")
+	fmt.Fprintln(w, "# TYPE spotter_telegram_get_updates_errors_total counter")
+	fmt.Fprintf(w, "spotter_telegram_get_updates_errors_total %d\n", metrics.telegramUpdatesErrors)
+
+	fmt.Fprintln(w, "# HELP spotter_seconds_since_last_successful_poll Time since presence was last polled successfully")
+	fmt.Fprintln(w, "# TYPE spotter_seconds_since_last_successful_poll gauge")
+	fmt.Fprintf(w, "spotter_seconds_since_last_successful_poll %g\n", time.Since(metrics.lastSuccessfulPoll).Seconds())
+}
+
+func (metrics *Metrics) handler(targets *Targets) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		targetsMutex.Lock()
+		targetsCount := len(*targets)
+		targetsMutex.Unlock()
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		metrics.write(w, targetsCount)
+	})
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		sinceLastSuccessfulPoll := metrics.sinceLastSuccessfulPoll()
+		if sinceLastSuccessfulPoll > healthyPollAge() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintf(w, "no successful poll for %s\n", sinceLastSuccessfulPoll.Round(time.Second))
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	return mux
+}
+
+// serveMetrics serves /metrics and /healthz on addr until ctx is done
+func serveMetrics(ctx context.Context, addr string, targets *Targets) {
+	server := &http.Server{
+		Addr:    addr,
+		Handler: metrics.handler(targets),
+	}
+	go func() {
+		<-ctx.Done()
+		server.Shutdown(context.Background())
+	}()
+	err := server.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		log.Println(err.Error())
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealthz(t *testing.T) {
+	tests := []struct {
+		name                    string
+		pollInterval            time.Duration
+		sinceLastSuccessfulPoll time.Duration
+		wantStatus              int
+	}{
+		{"recent poll", time.Second * 7, time.Second * 10, http.StatusOK},
+		{"short interval stuck", time.Second * 7, time.Minute * 2, http.StatusServiceUnavailable},
+		{"long interval between polls", time.Minute * 2, time.Minute * 3, http.StatusOK},
+		{"long interval stuck", time.Minute * 2, time.Minute * 7, http.StatusServiceUnavailable},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useTestGlobals(t)
+			getConfig().PollInterval = test.pollInterval
+			metrics := NewMetrics()
+			metrics.lastSuccessfulPoll = time.Now().Add(-test.sinceLastSuccessfulPoll)
+
+			recorder := httptest.NewRecorder()
+			metrics.handler(&targets).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+			if recorder.Code != test.wantStatus {
+				t.Errorf("status = %d, want %d", recorder.Code, test.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// State is everything that must survive bot's restart
+type State struct {
+	Targets Targets `json:"targets"`
+	// DashboardMessages are ids of dashboard messages by chat ids
+	DashboardMessages map[int]int `json:"dashboard_messages,omitempty"`
+	// QuietHours are set with /quiet and override config's ones
+	QuietHours string `json:"quiet_hours,omitempty"`
+	// UpdateOffset is id of the first update which isn't handled yet
+	UpdateOffset int `json:"update_offset,omitempty"`
+}
+
+// loadState returns empty state if there is no state file yet
+func loadState(path string) (*State, error) {
+	state := new(State)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, state)
+	if err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// saveState writes state to a temporary file first, so a crash while saving
+// doesn't leave a corrupted state file
+func saveState(path string, state *State) error {
+	data, err := json.MarshalIndent(state, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	_, err = tmpFile.Write(data)
+	if err != nil {
+		tmpFile.Close()
+		return err
+	}
+	err = tmpFile.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), path)
+}
@@ -0,0 +1,145 @@
+package telegram
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Callback data is "<version>:<signature>:<name>:<json payload>", or
+// "<version>:<signature>:<name>#<token>" if it doesn't fit into Telegram's
+// limit and the payload is kept in tokens table. Data of other versions is
+// rejected, so payloads may change safely. Signature is truncated HMAC-SHA256
+// of everything after it, so clients can't craft data themselves
+const (
+	callbackDataLimit      = 64
+	callbackDataVersion    = "2"
+	callbackSignatureBytes = 8
+	callbackTokensTtl      = time.Hour * 24 * 7
+)
+
+var (
+	ErrCallbackExpired   = errors.New("Callback data is outdated or unknown")
+	ErrCallbackSignature = errors.New("Callback data signature is invalid")
+)
+
+// CallbackAction is typed callback data, CallbackName selects the handler
+// registered with Router.HandleCallback and the rest is its JSON payload
+type CallbackAction interface {
+	CallbackName() string
+}
+
+type callbackToken struct {
+	payload     []byte
+	createdTime time.Time
+}
+
+// CallbackCodec encodes CallbackActions into callback data of inline buttons.
+// Tokens table is kept in memory, so buttons with long payloads stop working
+// after restart
+type CallbackCodec struct {
+	secret []byte
+	tokens map[string]callbackToken
+	mutex  sync.Mutex
+}
+
+// NewCallbackCodec takes secret callback data is signed with, buttons sent
+// before secret is changed stop working
+func NewCallbackCodec(secret []byte) *CallbackCodec {
+	return &CallbackCodec{
+		secret: secret,
+		tokens: map[string]callbackToken{},
+	}
+}
+
+func (codec *CallbackCodec) sign(signed string) string {
+	mac := hmac.New(sha256.New, codec.secret)
+	mac.Write([]byte(signed))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:callbackSignatureBytes])
+}
+
+func (codec *CallbackCodec) signedData(signed string) string {
+	return callbackDataVersion + ":" + codec.sign(signed) + ":" + signed
+}
+
+// Encode returns callback data of action, it fails if even the tokenized
+// data is longer than 64 bytes
+func (codec *CallbackCodec) Encode(action CallbackAction) (string, error) {
+	name := action.CallbackName()
+	if name == "" || strings.ContainsAny(name, ":#") {
+		return "", errors.New("Callback name must be non-empty and not contain ':' or '#'")
+	}
+	payload, err := json.Marshal(action)
+	if err != nil {
+		return "", err
+	}
+
+	data := codec.signedData(name + ":" + string(payload))
+	if len(data) <= callbackDataLimit {
+		return data, nil
+	}
+
+	token, err := codec.store(payload)
+	if err != nil {
+		return "", err
+	}
+	data = codec.signedData(name + "#" + token)
+	if len(data) > callbackDataLimit {
+		return "", errors.New("Callback name is too long: " + name)
+	}
+	return data, nil
+}
+
+func (codec *CallbackCodec) store(payload []byte) (string, error) {
+	tokenBytes := make([]byte, 8)
+	_, err := rand.Read(tokenBytes)
+	if err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(tokenBytes)
+
+	codec.mutex.Lock()
+	defer codec.mutex.Unlock()
+	now := time.Now()
+	for oldToken, entry := range codec.tokens {
+		if now.Sub(entry.createdTime) > callbackTokensTtl {
+			delete(codec.tokens, oldToken)
+		}
+	}
+	codec.tokens[token] = callbackToken{payload, now}
+	return token, nil
+}
+
+// decode returns action name and JSON payload of callback data
+func (codec *CallbackCodec) decode(data string) (string, []byte, error) {
+	parts := strings.SplitN(data, ":", 3)
+	if len(parts) != 3 || parts[0] != callbackDataVersion {
+		return "", nil, ErrCallbackExpired
+	}
+	signature, signed := parts[1], parts[2]
+	if !hmac.Equal([]byte(signature), []byte(codec.sign(signed))) {
+		return "", nil, ErrCallbackSignature
+	}
+
+	nameAndPayload := strings.SplitN(signed, ":", 2)
+	if len(nameAndPayload) == 2 {
+		return nameAndPayload[0], []byte(nameAndPayload[1]), nil
+	}
+	nameAndToken := strings.SplitN(signed, "#", 2)
+	if len(nameAndToken) != 2 {
+		return "", nil, ErrCallbackExpired
+	}
+	codec.mutex.Lock()
+	entry, found := codec.tokens[nameAndToken[1]]
+	codec.mutex.Unlock()
+	if !found {
+		return "", nil, ErrCallbackExpired
+	}
+	return nameAndToken[0], entry.payload, nil
+}
@@ -0,0 +1,112 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+)
+
+func setMyCommandsParams(params url.Values, config *MyCommandsConfig) error {
+	if config == nil {
+		return nil
+	}
+	if config.Scope != nil {
+		jsonScope, err := json.Marshal(config.Scope)
+		if err != nil {
+			return err
+		}
+		params.Set("scope", string(jsonScope))
+	}
+	if config.LanguageCode != "" {
+		params.Set("language_code", config.LanguageCode)
+	}
+	return nil
+}
+
+func (bot *Bot) SetMyCommands(commands []BotCommand, config *MyCommandsConfig) (bool, error) {
+	return bot.SetMyCommandsContext(context.Background(), commands, config)
+}
+
+func (bot *Bot) SetMyCommandsContext(ctx context.Context, commands []BotCommand, config *MyCommandsConfig) (bool, error) {
+	params := url.Values{}
+
+	if commands == nil {
+		commands = []BotCommand{}
+	}
+	jsonCommands, err := json.Marshal(commands)
+	if err != nil {
+		return false, err
+	}
+	params.Set("commands", string(jsonCommands))
+	err = setMyCommandsParams(params, config)
+	if err != nil {
+		return false, err
+	}
+
+	telegramResponse, err := bot.call(ctx, "setMyCommands", params)
+	if err != nil {
+		return false, err
+	}
+
+	if !telegramResponse.Ok {
+		return false, telegramResponse.apiError()
+	}
+
+	return true, nil
+}
+
+func (bot *Bot) GetMyCommands(config *MyCommandsConfig) ([]BotCommand, error) {
+	return bot.GetMyCommandsContext(context.Background(), config)
+}
+
+func (bot *Bot) GetMyCommandsContext(ctx context.Context, config *MyCommandsConfig) ([]BotCommand, error) {
+	params := url.Values{}
+
+	err := setMyCommandsParams(params, config)
+	if err != nil {
+		return nil, err
+	}
+
+	telegramResponse, err := bot.call(ctx, "getMyCommands", params)
+	if err != nil {
+		return nil, err
+	}
+
+	if !telegramResponse.Ok {
+		return nil, telegramResponse.apiError()
+	}
+
+	var commands []BotCommand
+	err = json.Unmarshal(*telegramResponse.Result, &commands)
+	if err != nil {
+		return nil, err
+	}
+
+	return commands, nil
+}
+
+// DeleteMyCommands deletes commands list of the scope and language, so
+// commands of a wider one are shown instead
+func (bot *Bot) DeleteMyCommands(config *MyCommandsConfig) (bool, error) {
+	return bot.DeleteMyCommandsContext(context.Background(), config)
+}
+
+func (bot *Bot) DeleteMyCommandsContext(ctx context.Context, config *MyCommandsConfig) (bool, error) {
+	params := url.Values{}
+
+	err := setMyCommandsParams(params, config)
+	if err != nil {
+		return false, err
+	}
+
+	telegramResponse, err := bot.call(ctx, "deleteMyCommands", params)
+	if err != nil {
+		return false, err
+	}
+
+	if !telegramResponse.Ok {
+		return false, telegramResponse.apiError()
+	}
+
+	return true, nil
+}
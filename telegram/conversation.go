@@ -0,0 +1,119 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+type conversationStep struct {
+	handler HandlerFunc
+	timer   *time.Timer
+}
+
+// Conversations let handlers ask for input in several steps: next message of
+// the chat is routed to the step handler set with Context.Ask instead of the
+// router. Conversation ends if there's no answer within timeout, if a command
+// or button is sent instead of answer, or if step handler doesn't ask again
+type Conversations struct {
+	timeout     time.Duration
+	timeoutText string
+	steps       map[int]*conversationStep
+	mutex       sync.Mutex
+}
+
+// NewConversations takes text sent to the chat when conversation times out,
+// nothing is sent if it's empty
+func NewConversations(timeout time.Duration, timeoutText string) *Conversations {
+	return &Conversations{
+		timeout:     timeout,
+		timeoutText: timeoutText,
+		steps:       map[int]*conversationStep{},
+	}
+}
+
+// Middleware must be used after authorization, so only authorized users can
+// answer. Callback queries don't affect conversations
+func (conversations *Conversations) Middleware() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx *Context) {
+			ctx.conversations = conversations
+			if ctx.CallbackQuery != nil {
+				next(ctx)
+				return
+			}
+			step := conversations.take(ctx.chatId())
+			if step != nil && ctx.Command == "" {
+				step(ctx)
+				return
+			}
+			ctx.interrupted = step != nil
+			next(ctx)
+		}
+	}
+}
+
+// expect routes chat's next message to step, replacing the current step
+func (conversations *Conversations) expect(bot *Bot, chatId int, step HandlerFunc) {
+	conversations.mutex.Lock()
+	defer conversations.mutex.Unlock()
+	if current, found := conversations.steps[chatId]; found {
+		current.timer.Stop()
+	}
+	newStep := &conversationStep{handler: step}
+	newStep.timer = time.AfterFunc(conversations.timeout, func() {
+		conversations.mutex.Lock()
+		timedOut := conversations.steps[chatId] == newStep
+		if timedOut {
+			delete(conversations.steps, chatId)
+		}
+		conversations.mutex.Unlock()
+		if timedOut && conversations.timeoutText != "" {
+			_, err := bot.SendMessageContext(context.Background(), chatId, conversations.timeoutText, nil)
+			if err != nil {
+				log.Println(err.Error())
+			}
+		}
+	})
+	conversations.steps[chatId] = newStep
+}
+
+// take ends chat's conversation and returns its step, if there was one
+func (conversations *Conversations) take(chatId int) HandlerFunc {
+	conversations.mutex.Lock()
+	defer conversations.mutex.Unlock()
+	step, found := conversations.steps[chatId]
+	if !found {
+		return nil
+	}
+	step.timer.Stop()
+	delete(conversations.steps, chatId)
+	return step.handler
+}
+
+// Ask replies with text and routes the chat's next message to step
+func (ctx *Context) Ask(text string, config *SendMessageConfig, step HandlerFunc) (*Message, error) {
+	if ctx.conversations == nil {
+		return nil, errors.New("Conversations middleware isn't used")
+	}
+	ctx.conversations.expect(ctx.Bot, ctx.chatId(), step)
+	message, err := ctx.Reply(text, config)
+	if err != nil {
+		ctx.conversations.take(ctx.chatId())
+		return nil, err
+	}
+	return message, nil
+}
+
+// EndConversation ends the chat's conversation and reports if there was one,
+// including the one interrupted by the handled message
+func (ctx *Context) EndConversation() bool {
+	if ctx.conversations == nil {
+		return false
+	}
+	ended := ctx.conversations.take(ctx.chatId()) != nil || ctx.interrupted
+	ctx.interrupted = false
+	return ended
+}
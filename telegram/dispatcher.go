@@ -0,0 +1,108 @@
+package telegram
+
+import (
+	"log"
+	"runtime/debug"
+	"sync"
+)
+
+// Dispatcher handles updates with a bounded number of workers. Updates of
+// the same chat are handled one by one in the order they came, updates of
+// different chats are handled in parallel
+type Dispatcher struct {
+	handler func(update Update)
+	workers chan struct{}
+	// queues are updates of chats which are being handled, the first one of
+	// each queue is being handled right now
+	queues map[int][]Update
+	mutex  sync.Mutex
+	wg     sync.WaitGroup
+}
+
+func NewDispatcher(workers int, handler func(update Update)) *Dispatcher {
+	return &Dispatcher{
+		handler: handler,
+		workers: make(chan struct{}, workers),
+		queues:  map[int][]Update{},
+	}
+}
+
+// updateChatId returns id of the chat update belongs to, updates without
+// chat are ordered by sender
+func updateChatId(update Update) int {
+	switch {
+	case update.Message != nil:
+		return update.Message.Chat.Id
+	case update.CallbackQuery != nil && update.CallbackQuery.Message != nil:
+		return update.CallbackQuery.Message.Chat.Id
+	case update.CallbackQuery != nil && update.CallbackQuery.From != nil:
+		return update.CallbackQuery.From.Id
+	case update.InlineQuery != nil && update.InlineQuery.From != nil:
+		return update.InlineQuery.From.Id
+	case update.EditedMessage != nil:
+		return update.EditedMessage.Chat.Id
+	case update.ChannelPost != nil:
+		return update.ChannelPost.Chat.Id
+	case update.EditedChannelPost != nil:
+		return update.EditedChannelPost.Chat.Id
+	}
+	return 0
+}
+
+// Dispatch queues update, it blocks while all workers are busy with other
+// chats
+func (dispatcher *Dispatcher) Dispatch(update Update) {
+	chatId := updateChatId(update)
+
+	dispatcher.mutex.Lock()
+	queue, handling := dispatcher.queues[chatId]
+	dispatcher.queues[chatId] = append(queue, update)
+	dispatcher.mutex.Unlock()
+	if handling {
+		return
+	}
+
+	dispatcher.workers <- struct{}{}
+	dispatcher.wg.Add(1)
+	go dispatcher.work(chatId)
+}
+
+// work handles chat's updates until its queue is empty
+func (dispatcher *Dispatcher) work(chatId int) {
+	defer func() {
+		<-dispatcher.workers
+		dispatcher.wg.Done()
+	}()
+	for {
+		dispatcher.mutex.Lock()
+		update := dispatcher.queues[chatId][0]
+		dispatcher.mutex.Unlock()
+
+		dispatcher.handle(update)
+
+		dispatcher.mutex.Lock()
+		queue := dispatcher.queues[chatId][1:]
+		if len(queue) == 0 {
+			delete(dispatcher.queues, chatId)
+			dispatcher.mutex.Unlock()
+			return
+		}
+		dispatcher.queues[chatId] = queue
+		dispatcher.mutex.Unlock()
+	}
+}
+
+// handle recovers handler's panic, so the chat's queue isn't stuck
+func (dispatcher *Dispatcher) handle(update Update) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Panic while handling update %d: %v\n%s", update.UpdateId, r, debug.Stack())
+		}
+	}()
+	dispatcher.handler(update)
+}
+
+// Wait waits until every dispatched update is handled
+func (dispatcher *Dispatcher) Wait() {
+	dispatcher.wg.Wait()
+}
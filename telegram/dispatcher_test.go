@@ -0,0 +1,102 @@
+package telegram
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func chatUpdate(updateId int, chatId int) Update {
+	return Update{
+		UpdateId: updateId,
+		Message:  &Message{MessageId: updateId, Chat: Chat{Id: chatId}},
+	}
+}
+
+func TestDispatcherOrdersUpdatesOfChat(t *testing.T) {
+	const chats = 5
+	const updatesPerChat = 20
+	const workers = 2
+
+	mutex := sync.Mutex{}
+	handled := map[int][]int{}
+	busy := 0
+	maxBusy := 0
+	dispatcher := NewDispatcher(workers, func(update Update) {
+		mutex.Lock()
+		busy++
+		if busy > maxBusy {
+			maxBusy = busy
+		}
+		mutex.Unlock()
+
+		time.Sleep(time.Millisecond)
+
+		mutex.Lock()
+		busy--
+		chatId := update.Message.Chat.Id
+		handled[chatId] = append(handled[chatId], update.UpdateId)
+		mutex.Unlock()
+	})
+
+	wantHandled := map[int][]int{}
+	for updateId := 0; updateId < chats*updatesPerChat; updateId++ {
+		chatId := updateId % chats
+		wantHandled[chatId] = append(wantHandled[chatId], updateId)
+		dispatcher.Dispatch(chatUpdate(updateId, chatId))
+	}
+	dispatcher.Wait()
+
+	if !reflect.DeepEqual(handled, wantHandled) {
+		t.Errorf("handled = %v, want %v", handled, wantHandled)
+	}
+	if maxBusy > workers {
+		t.Errorf("%d updates were handled at once, want at most %d", maxBusy, workers)
+	}
+	if maxBusy < workers {
+		t.Errorf("%d updates were handled at once, want chats handled in parallel", maxBusy)
+	}
+}
+
+func TestDispatcherRecoversPanic(t *testing.T) {
+	handled := []int{}
+	dispatcher := NewDispatcher(1, func(update Update) {
+		handled = append(handled, update.UpdateId)
+		if update.UpdateId == 1 {
+			panic("handler failed")
+		}
+	})
+
+	for updateId := 1; updateId <= 3; updateId++ {
+		dispatcher.Dispatch(chatUpdate(updateId, 1))
+	}
+	dispatcher.Wait()
+
+	// Updates after the panicked one are still handled
+	if want := []int{1, 2, 3}; !reflect.DeepEqual(handled, want) {
+		t.Errorf("handled = %v, want %v", handled, want)
+	}
+}
+
+func TestUpdateChatId(t *testing.T) {
+	tests := []struct {
+		name   string
+		update Update
+		want   int
+	}{
+		{"message", chatUpdate(1, 10), 10},
+		{"callback query", Update{CallbackQuery: &CallbackQuery{From: &User{Id: 20}, Message: &Message{Chat: Chat{Id: 10}}}}, 10},
+		{"inline callback query", Update{CallbackQuery: &CallbackQuery{From: &User{Id: 20}}}, 20},
+		{"inline query", Update{InlineQuery: &InlineQuery{From: &User{Id: 30}}}, 30},
+		{"unknown", Update{}, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if chatId := updateChatId(test.update); chatId != test.want {
+				t.Errorf("updateChatId() = %d, want %d", chatId, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strconv"
+)
+
+// Messages sent by the bot are edited by chatId and messageId, messages sent
+// via the bot in inline mode are edited by inlineMessageId. Telegram returns
+// edited message for the former and just true for the latter
+
+func (bot *Bot) editMessage(ctx context.Context, methodName string, chatId int, messageId int, params url.Values) (*Message, error) {
+	params.Set("chat_id", strconv.Itoa(chatId))
+	params.Set("message_id", strconv.Itoa(messageId))
+
+	telegramResponse, err := bot.call(ctx, methodName, params)
+	if err != nil {
+		return nil, err
+	}
+
+	if !telegramResponse.Ok {
+		return nil, telegramResponse.apiError()
+	}
+
+	message := new(Message)
+	err = json.Unmarshal(*telegramResponse.Result, message)
+	if err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+func (bot *Bot) editInlineMessage(ctx context.Context, methodName string, inlineMessageId string, params url.Values) (bool, error) {
+	params.Set("inline_message_id", inlineMessageId)
+
+	telegramResponse, err := bot.call(ctx, methodName, params)
+	if err != nil {
+		return false, err
+	}
+
+	if !telegramResponse.Ok {
+		return false, telegramResponse.apiError()
+	}
+
+	return true, nil
+}
+
+func editMessageTextParams(text string, config *EditMessageTextConfig) (url.Values, error) {
+	params := url.Values{}
+
+	params.Set("text", text)
+	if config != nil {
+		if config.ParseMode != "" {
+			params.Set("parse_mode", config.ParseMode)
+		}
+		if config.DisableWebPagePreview {
+			params.Set("disable_web_page_preview", "true")
+		}
+		err := setReplyMarkup(params, config.ReplyMarkup)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return params, nil
+}
+
+func (bot *Bot) EditMessageText(chatId int, messageId int, text string, config *EditMessageTextConfig) (*Message, error) {
+	return bot.EditMessageTextContext(context.Background(), chatId, messageId, text, config)
+}
+
+func (bot *Bot) EditMessageTextContext(ctx context.Context, chatId int, messageId int, text string, config *EditMessageTextConfig) (*Message, error) {
+	params, err := editMessageTextParams(text, config)
+	if err != nil {
+		return nil, err
+	}
+	return bot.editMessage(ctx, "editMessageText", chatId, messageId, params)
+}
+
+func (bot *Bot) EditInlineMessageText(inlineMessageId string, text string, config *EditMessageTextConfig) (bool, error) {
+	return bot.EditInlineMessageTextContext(context.Background(), inlineMessageId, text, config)
+}
+
+func (bot *Bot) EditInlineMessageTextContext(ctx context.Context, inlineMessageId string, text string, config *EditMessageTextConfig) (bool, error) {
+	params, err := editMessageTextParams(text, config)
+	if err != nil {
+		return false, err
+	}
+	return bot.editInlineMessage(ctx, "editMessageText", inlineMessageId, params)
+}
+
+func editMessageCaptionParams(caption string, config *EditMessageCaptionConfig) (url.Values, error) {
+	params := url.Values{}
+
+	params.Set("caption", caption)
+	if config != nil {
+		if config.ParseMode != "" {
+			params.Set("parse_mode", config.ParseMode)
+		}
+		err := setReplyMarkup(params, config.ReplyMarkup)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return params, nil
+}
+
+func (bot *Bot) EditMessageCaption(chatId int, messageId int, caption string, config *EditMessageCaptionConfig) (*Message, error) {
+	return bot.EditMessageCaptionContext(context.Background(), chatId, messageId, caption, config)
+}
+
+func (bot *Bot) EditMessageCaptionContext(ctx context.Context, chatId int, messageId int, caption string, config *EditMessageCaptionConfig) (*Message, error) {
+	params, err := editMessageCaptionParams(caption, config)
+	if err != nil {
+		return nil, err
+	}
+	return bot.editMessage(ctx, "editMessageCaption", chatId, messageId, params)
+}
+
+func (bot *Bot) EditInlineMessageCaption(inlineMessageId string, caption string, config *EditMessageCaptionConfig) (bool, error) {
+	return bot.EditInlineMessageCaptionContext(context.Background(), inlineMessageId, caption, config)
+}
+
+func (bot *Bot) EditInlineMessageCaptionContext(ctx context.Context, inlineMessageId string, caption string, config *EditMessageCaptionConfig) (bool, error) {
+	params, err := editMessageCaptionParams(caption, config)
+	if err != nil {
+		return false, err
+	}
+	return bot.editInlineMessage(ctx, "editMessageCaption", inlineMessageId, params)
+}
+
+func (bot *Bot) EditInlineMessageReplyMarkup(inlineMessageId string, replyMarkup *ReplyMarkup) (bool, error) {
+	return bot.EditInlineMessageReplyMarkupContext(context.Background(), inlineMessageId, replyMarkup)
+}
+
+func (bot *Bot) EditInlineMessageReplyMarkupContext(ctx context.Context, inlineMessageId string, replyMarkup *ReplyMarkup) (bool, error) {
+	params := url.Values{}
+	err := setReplyMarkup(params, replyMarkup)
+	if err != nil {
+		return false, err
+	}
+	return bot.editInlineMessage(ctx, "editMessageReplyMarkup", inlineMessageId, params)
+}
+
+func (bot *Bot) DeleteMessage(chatId int, messageId int) (bool, error) {
+	return bot.DeleteMessageContext(context.Background(), chatId, messageId)
+}
+
+func (bot *Bot) DeleteMessageContext(ctx context.Context, chatId int, messageId int) (bool, error) {
+	params := url.Values{}
+
+	params.Set("chat_id", strconv.Itoa(chatId))
+	params.Set("message_id", strconv.Itoa(messageId))
+
+	telegramResponse, err := bot.call(ctx, "deleteMessage", params)
+	if err != nil {
+		return false, err
+	}
+
+	if !telegramResponse.Ok {
+		return false, telegramResponse.apiError()
+	}
+
+	return true, nil
+}
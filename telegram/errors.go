@@ -0,0 +1,67 @@
+package telegram
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// APIError is returned by Bot methods when Telegram responds with ok=false
+type APIError struct {
+	Code            int
+	Description     string
+	RetryAfter      int
+	MigrateToChatId int
+}
+
+func (err *APIError) Error() string {
+	return err.Description
+}
+
+func (response *Response) apiError() error {
+	apiError := &APIError{
+		Code:        response.ErrorCode,
+		Description: "No result in TelegramResponse",
+	}
+	if response.Description != nil {
+		apiError.Description = *response.Description
+	}
+	if response.ResponseParameters != nil {
+		if response.ResponseParameters.RetryAfter != nil {
+			apiError.RetryAfter = *response.ResponseParameters.RetryAfter
+		}
+		if response.ResponseParameters.MigrateToChatId != nil {
+			apiError.MigrateToChatId = *response.ResponseParameters.MigrateToChatId
+		}
+	}
+	return apiError
+}
+
+// Telegram tells error reasons only in descriptions, so helpers below match
+// them along with error codes
+
+func isAPIError(err error, code int, descriptionPart string) bool {
+	var apiError *APIError
+	if !errors.As(err, &apiError) {
+		return false
+	}
+	return apiError.Code == code && strings.Contains(strings.ToLower(apiError.Description), descriptionPart)
+}
+
+// IsBlockedByUser reports if user stopped the bot, so it can't message them
+func IsBlockedByUser(err error) bool {
+	return isAPIError(err, http.StatusForbidden, "bot was blocked by the user")
+}
+
+// IsMessageNotModified reports if edit didn't change the message
+func IsMessageNotModified(err error) bool {
+	return isAPIError(err, http.StatusBadRequest, "message is not modified")
+}
+
+func IsChatNotFound(err error) bool {
+	return isAPIError(err, http.StatusBadRequest, "chat not found")
+}
+
+func IsMessageToEditNotFound(err error) bool {
+	return isAPIError(err, http.StatusBadRequest, "message to edit not found")
+}
@@ -0,0 +1,235 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/url"
+	"strconv"
+)
+
+// InputFile is a file to send: either uploaded from Reader, or already
+// stored on Telegram servers by FileId, or downloaded by Telegram from Url
+type InputFile struct {
+	Name   string
+	Reader io.Reader
+	FileId string
+	Url    string
+}
+
+func FileFromReader(name string, reader io.Reader) InputFile {
+	return InputFile{Name: name, Reader: reader}
+}
+
+func FileFromId(fileId string) InputFile {
+	return InputFile{FileId: fileId}
+}
+
+func FileFromUrl(url string) InputFile {
+	return InputFile{Url: url}
+}
+
+func (file InputFile) isUpload() bool {
+	return file.Reader != nil
+}
+
+// value is what's sent in place of the file when it's not uploaded
+func (file InputFile) value() (string, error) {
+	if file.FileId != "" {
+		return file.FileId, nil
+	}
+	if file.Url != "" {
+		return file.Url, nil
+	}
+	return "", errors.New("InputFile has neither Reader, FileId nor Url")
+}
+
+// encodeRequest encodes params with files as form, multipart one if any
+// file is uploaded
+func encodeRequest(params url.Values, files map[string]InputFile) ([]byte, string, error) {
+	upload := false
+	for fieldName, file := range files {
+		if file.isUpload() {
+			upload = true
+			continue
+		}
+		value, err := file.value()
+		if err != nil {
+			return nil, "", err
+		}
+		params.Set(fieldName, value)
+	}
+	if !upload {
+		return []byte(params.Encode()), "application/x-www-form-urlencoded", nil
+	}
+
+	body := new(bytes.Buffer)
+	writer := multipart.NewWriter(body)
+	for key, values := range params {
+		for _, value := range values {
+			err := writer.WriteField(key, value)
+			if err != nil {
+				return nil, "", err
+			}
+		}
+	}
+	for fieldName, file := range files {
+		if !file.isUpload() {
+			continue
+		}
+		name := file.Name
+		if name == "" {
+			name = fieldName
+		}
+		part, err := writer.CreateFormFile(fieldName, name)
+		if err != nil {
+			return nil, "", err
+		}
+		_, err = io.Copy(part, file.Reader)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	err := writer.Close()
+	if err != nil {
+		return nil, "", err
+	}
+	return body.Bytes(), writer.FormDataContentType(), nil
+}
+
+// setSendMediaParams sets params shared by SendPhoto and SendDocument
+func setSendMediaParams(params url.Values, config *SendMediaConfig) error {
+	if config == nil {
+		return nil
+	}
+	if config.Caption != "" {
+		params.Set("caption", config.Caption)
+	}
+	if config.ParseMode != "" {
+		params.Set("parse_mode", config.ParseMode)
+	}
+	if config.DisableNotification {
+		params.Set("disable_notification", "true")
+	}
+	if config.ReplyToMessageId != 0 {
+		params.Set("reply_to_message_id", strconv.Itoa(config.ReplyToMessageId))
+	}
+	if config.AllowSendingWithoutReply {
+		params.Set("allow_sending_without_reply", "true")
+	}
+	return setReplyMarkup(params, config.ReplyMarkup)
+}
+
+func (bot *Bot) sendFile(ctx context.Context, methodName string, fieldName string, chatId int, file InputFile, config *SendMediaConfig) (*Message, error) {
+	params := url.Values{}
+
+	params.Set("chat_id", strconv.Itoa(chatId))
+	err := setSendMediaParams(params, config)
+	if err != nil {
+		return nil, err
+	}
+
+	telegramResponse, err := bot.callWithFiles(ctx, methodName, params, map[string]InputFile{fieldName: file})
+	if err != nil {
+		return nil, err
+	}
+
+	if !telegramResponse.Ok {
+		return nil, telegramResponse.apiError()
+	}
+
+	message := new(Message)
+	err = json.Unmarshal(*telegramResponse.Result, message)
+	if err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+func (bot *Bot) SendPhoto(chatId int, photo InputFile, config *SendMediaConfig) (*Message, error) {
+	return bot.SendPhotoContext(context.Background(), chatId, photo, config)
+}
+
+func (bot *Bot) SendPhotoContext(ctx context.Context, chatId int, photo InputFile, config *SendMediaConfig) (*Message, error) {
+	return bot.sendFile(ctx, "sendPhoto", "photo", chatId, photo, config)
+}
+
+func (bot *Bot) SendDocument(chatId int, document InputFile, config *SendMediaConfig) (*Message, error) {
+	return bot.SendDocumentContext(context.Background(), chatId, document, config)
+}
+
+func (bot *Bot) SendDocumentContext(ctx context.Context, chatId int, document InputFile, config *SendMediaConfig) (*Message, error) {
+	return bot.sendFile(ctx, "sendDocument", "document", chatId, document, config)
+}
+
+func (bot *Bot) SendMediaGroup(chatId int, media []InputMedia, config *SendMediaGroupConfig) ([]Message, error) {
+	return bot.SendMediaGroupContext(context.Background(), chatId, media, config)
+}
+
+// SendMediaGroupContext sends 2-10 photos or documents as an album. Captions
+// and parse modes are set per media, reply markup isn't supported by Telegram
+func (bot *Bot) SendMediaGroupContext(ctx context.Context, chatId int, media []InputMedia, config *SendMediaGroupConfig) ([]Message, error) {
+	params := url.Values{}
+
+	params.Set("chat_id", strconv.Itoa(chatId))
+	if config != nil {
+		if config.DisableNotification {
+			params.Set("disable_notification", "true")
+		}
+		if config.ReplyToMessageId != 0 {
+			params.Set("reply_to_message_id", strconv.Itoa(config.ReplyToMessageId))
+		}
+		if config.AllowSendingWithoutReply {
+			params.Set("allow_sending_without_reply", "true")
+		}
+	}
+
+	// Uploaded files are referenced from media as attach://<field name>
+	files := map[string]InputFile{}
+	jsonMedia := make([]inputMediaJson, len(media))
+	for i, item := range media {
+		jsonMedia[i] = inputMediaJson{
+			Type:      item.Type,
+			Caption:   item.Caption,
+			ParseMode: item.ParseMode,
+		}
+		if item.Media.isUpload() {
+			fieldName := fmt.Sprintf("file%d", i)
+			files[fieldName] = item.Media
+			jsonMedia[i].Media = "attach://" + fieldName
+			continue
+		}
+		value, err := item.Media.value()
+		if err != nil {
+			return nil, err
+		}
+		jsonMedia[i].Media = value
+	}
+	mediaParam, err := json.Marshal(jsonMedia)
+	if err != nil {
+		return nil, err
+	}
+	params.Set("media", string(mediaParam))
+
+	telegramResponse, err := bot.callWithFiles(ctx, "sendMediaGroup", params, files)
+	if err != nil {
+		return nil, err
+	}
+
+	if !telegramResponse.Ok {
+		return nil, telegramResponse.apiError()
+	}
+
+	var messages []Message
+	err = json.Unmarshal(*telegramResponse.Result, &messages)
+	if err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
@@ -0,0 +1,37 @@
+package telegram
+
+import (
+	"strings"
+)
+
+// Parse modes of message text and captions
+const (
+	ParseModeHTML       = "HTML"
+	ParseModeMarkdownV2 = "MarkdownV2"
+)
+
+var htmlReplacer = strings.NewReplacer(
+	"&", "&amp;",
+	"<", "&lt;",
+	">", "&gt;",
+	`"`, "&quot;",
+)
+
+// EscapeHTML escapes text to be shown as is in HTML parse mode, both in text
+// and in attribute values
+func EscapeHTML(text string) string {
+	return htmlReplacer.Replace(text)
+}
+
+var markdownV2Replacer = strings.NewReplacer(
+	`\`, `\\`, "_", `\_`, "*", `\*`, "[", `\[`, "]", `\]`, "(", `\(`,
+	")", `\)`, "~", `\~`, "`", "\\`", ">", `\>`, "#", `\#`, "+", `\+`,
+	"-", `\-`, "=", `\=`, "|", `\|`, "{", `\{`, "}", `\}`, ".", `\.`,
+	"!", `\!`,
+)
+
+// EscapeMarkdownV2 escapes text to be shown as is in MarkdownV2 parse mode,
+// outside of code entities and link urls
+func EscapeMarkdownV2(text string) string {
+	return markdownV2Replacer.Replace(text)
+}
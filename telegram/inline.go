@@ -0,0 +1,58 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strconv"
+)
+
+func (InlineQueryResultArticle) inlineQueryResult() {}
+
+// MarshalJSON adds type of the result, so it doesn't have to be set manually
+func (result InlineQueryResultArticle) MarshalJSON() ([]byte, error) {
+	type article InlineQueryResultArticle
+	return json.Marshal(struct {
+		Type string `json:"type"`
+		article
+	}{"article", article(result)})
+}
+
+func (bot *Bot) AnswerInlineQuery(inlineQueryId string, results []InlineQueryResult, config *AnswerInlineQueryConfig) (bool, error) {
+	return bot.AnswerInlineQueryContext(context.Background(), inlineQueryId, results, config)
+}
+
+// AnswerInlineQueryContext answers with up to 50 results
+func (bot *Bot) AnswerInlineQueryContext(ctx context.Context, inlineQueryId string, results []InlineQueryResult, config *AnswerInlineQueryConfig) (bool, error) {
+	params := url.Values{}
+
+	params.Set("inline_query_id", inlineQueryId)
+	if results == nil {
+		results = []InlineQueryResult{}
+	}
+	jsonResults, err := json.Marshal(results)
+	if err != nil {
+		return false, err
+	}
+	params.Set("results", string(jsonResults))
+	if config != nil {
+		params.Set("cache_time", strconv.Itoa(config.CacheTime))
+		if config.IsPersonal {
+			params.Set("is_personal", "true")
+		}
+		if config.NextOffset != "" {
+			params.Set("next_offset", config.NextOffset)
+		}
+	}
+
+	telegramResponse, err := bot.call(ctx, "answerInlineQuery", params)
+	if err != nil {
+		return false, err
+	}
+
+	if !telegramResponse.Ok {
+		return false, telegramResponse.apiError()
+	}
+
+	return true, nil
+}
@@ -0,0 +1,61 @@
+package telegram
+
+import (
+	"sync"
+	"time"
+)
+
+// Telegram drops messages sent faster than about 30 per second overall and
+// 1 per second into the same chat
+const (
+	globalMessagesInterval = time.Second / 30
+	chatMessagesInterval   = time.Second
+)
+
+// rateLimiter spaces out requests sending messages, so bursts are delayed
+// instead of failing with 429
+type rateLimiter struct {
+	globalInterval time.Duration
+	chatInterval   time.Duration
+	nextGlobal     time.Time
+	nextByChat     map[string]time.Time
+	mutex          sync.Mutex
+}
+
+func newRateLimiter(globalInterval, chatInterval time.Duration) *rateLimiter {
+	return &rateLimiter{
+		globalInterval: globalInterval,
+		chatInterval:   chatInterval,
+		nextByChat:     map[string]time.Time{},
+	}
+}
+
+// reserve books a slot for a message into chatId and returns how long to
+// wait before sending it
+func (limiter *rateLimiter) reserve(chatId string) time.Duration {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	now := time.Now()
+	at := now
+	if limiter.nextGlobal.After(at) {
+		at = limiter.nextGlobal
+	}
+	if nextChat := limiter.nextByChat[chatId]; nextChat.After(at) {
+		at = nextChat
+	}
+	limiter.nextGlobal = at.Add(limiter.globalInterval)
+	limiter.nextByChat[chatId] = at.Add(limiter.chatInterval)
+
+	// Chats which slots have passed don't limit anything, so they're
+	// forgotten not to grow the map forever
+	if len(limiter.nextByChat) > 1000 {
+		for chat, next := range limiter.nextByChat {
+			if next.Before(now) {
+				delete(limiter.nextByChat, chat)
+			}
+		}
+	}
+
+	return at.Sub(now)
+}
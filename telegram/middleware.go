@@ -0,0 +1,110 @@
+package telegram
+
+import (
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// Recover logs panics of handlers instead of crashing the bot
+func Recover() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx *Context) {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("Panic while handling %s: %v\n%s", ctx.Command, r, debug.Stack())
+				}
+			}()
+			next(ctx)
+		}
+	}
+}
+
+// Logger logs every handled message's command, sender and handling time
+func Logger() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx *Context) {
+			startTime := time.Now()
+			next(ctx)
+			command := ctx.Command
+			if command == "" {
+				command = "message"
+			}
+			log.Printf("%s from chat %d handled in %s", command, ctx.chatId(), time.Since(startTime))
+		}
+	}
+}
+
+// Authorize ignores messages and callback queries for which allowed returns
+// false. Ignored callback queries are answered, so button stops loading
+func Authorize(allowed func(ctx *Context) bool) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx *Context) {
+			if !allowed(ctx) {
+				if ctx.CallbackQuery != nil {
+					ctx.Answer("", false)
+				}
+				return
+			}
+			next(ctx)
+		}
+	}
+}
+
+// RateLimit ignores messages from a chat coming faster than one per interval
+// after burst of messages. The first ignored message is replied with text,
+// and every ignored callback query is answered with it
+func RateLimit(interval time.Duration, burst int, text string) Middleware {
+	type bucket struct {
+		tokens      float64
+		updatedTime time.Time
+		// replied is set when chat was told about the limit and reset when
+		// its message is allowed again
+		replied bool
+	}
+	buckets := map[int]*bucket{}
+	mutex := sync.Mutex{}
+
+	// allow returns whether message is allowed and whether chat must be told
+	// it isn't
+	allow := func(chatId int) (bool, bool) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		now := time.Now()
+		chatBucket, found := buckets[chatId]
+		if !found {
+			chatBucket = &bucket{tokens: float64(burst), updatedTime: now}
+			buckets[chatId] = chatBucket
+		}
+		chatBucket.tokens += float64(now.Sub(chatBucket.updatedTime)) / float64(interval)
+		if chatBucket.tokens > float64(burst) {
+			chatBucket.tokens = float64(burst)
+		}
+		chatBucket.updatedTime = now
+		if chatBucket.tokens < 1 {
+			reply := !chatBucket.replied
+			chatBucket.replied = true
+			return false, reply
+		}
+		chatBucket.tokens--
+		chatBucket.replied = false
+		return true, false
+	}
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx *Context) {
+			allowed, reply := allow(ctx.chatId())
+			if !allowed {
+				log.Printf("Update from chat %d ignored by rate limit", ctx.chatId())
+				if ctx.CallbackQuery != nil {
+					ctx.Answer(text, false)
+				} else if reply {
+					ctx.Reply(text, nil)
+				}
+				return
+			}
+			next(ctx)
+		}
+	}
+}
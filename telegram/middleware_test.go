@@ -0,0 +1,132 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newRecordingBot returns bot whose requests are answered with success and
+// remembered as method and params
+func newRecordingBot(t *testing.T) (*Bot, func() []string) {
+	mutex := sync.Mutex{}
+	requests := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		method := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		mutex.Lock()
+		requests = append(requests, method+" "+url.QueryEscape(r.PostForm.Get("text")))
+		mutex.Unlock()
+		result := "true"
+		if method == "sendMessage" {
+			result = `{"message_id": 1, "date": 0, "chat": {"id": 1, "type": "private"}}`
+		}
+		fmt.Fprintf(w, `{"ok": true, "result": %s}`, result)
+	}))
+	t.Cleanup(server.Close)
+
+	return NewBot("token", WithBaseUrl(server.URL)), func() []string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]string{}, requests...)
+	}
+}
+
+func middlewareContext(bot *Bot, callbackQuery bool) *Context {
+	ctx := &Context{
+		Context: context.Background(),
+		Bot:     bot,
+		Message: &Message{MessageId: 1, Chat: Chat{Id: 1, Type: "private"}},
+	}
+	if callbackQuery {
+		ctx.CallbackQuery = &CallbackQuery{Id: "query", From: &User{Id: 1}, Message: ctx.Message}
+	}
+	return ctx
+}
+
+func TestAuthorize(t *testing.T) {
+	tests := []struct {
+		name          string
+		allowed       bool
+		callbackQuery bool
+		wantHandled   bool
+		wantRequests  []string
+	}{
+		{"allowed message", true, false, true, []string{}},
+		{"denied message", false, false, false, []string{}},
+		{"allowed callback query", true, true, true, []string{}},
+		// Button would keep loading if query isn't answered
+		{"denied callback query", false, true, false, []string{"answerCallbackQuery "}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bot, requests := newRecordingBot(t)
+			handled := false
+			handler := Authorize(func(ctx *Context) bool { return test.allowed })(func(ctx *Context) {
+				handled = true
+			})
+
+			handler(middlewareContext(bot, test.callbackQuery))
+
+			if handled != test.wantHandled {
+				t.Errorf("handled = %v, want %v", handled, test.wantHandled)
+			}
+			if got := requests(); fmt.Sprint(got) != fmt.Sprint(test.wantRequests) {
+				t.Errorf("requests = %q, want %q", got, test.wantRequests)
+			}
+		})
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	const text = "slow down"
+	escapedText := url.QueryEscape(text)
+
+	tests := []struct {
+		name          string
+		callbackQuery bool
+		wantHandled   int
+		wantRequests  []string
+	}{
+		{
+			// Chat is told about the limit once, not on every message
+			name:         "messages",
+			wantHandled:  1,
+			wantRequests: []string{"sendMessage " + escapedText},
+		},
+		{
+			name:          "callback queries",
+			callbackQuery: true,
+			wantHandled:   1,
+			wantRequests:  []string{"answerCallbackQuery " + escapedText, "answerCallbackQuery " + escapedText},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bot, requests := newRecordingBot(t)
+			handled := 0
+			handler := RateLimit(time.Hour, 1, text)(func(ctx *Context) {
+				handled++
+			})
+
+			for i := 0; i < 3; i++ {
+				handler(middlewareContext(bot, test.callbackQuery))
+			}
+
+			if handled != test.wantHandled {
+				t.Errorf("handled = %d, want %d", handled, test.wantHandled)
+			}
+			if got := requests(); fmt.Sprint(got) != fmt.Sprint(test.wantRequests) {
+				t.Errorf("requests = %q, want %q", got, test.wantRequests)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package telegram
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultBaseUrl = "https://api.telegram.org"
+	defaultTimeout = time.Second * 30
+)
+
+// BotOption changes bot's default settings in NewBot
+type BotOption func(bot *Bot)
+
+// WithHttpClient makes bot send requests with client instead of
+// http.DefaultClient
+func WithHttpClient(client *http.Client) BotOption {
+	return func(bot *Bot) {
+		bot.httpClient = client
+	}
+}
+
+// WithBaseUrl points bot to another Bot API server, like a local one
+func WithBaseUrl(baseUrl string) BotOption {
+	return func(bot *Bot) {
+		bot.baseUrl = strings.TrimSuffix(baseUrl, "/")
+	}
+}
+
+// WithTimeout limits time of every request. getUpdates gets its long polling
+// timeout on top of it
+func WithTimeout(timeout time.Duration) BotOption {
+	return func(bot *Bot) {
+		bot.timeout = timeout
+	}
+}
+
+func WithUserAgent(userAgent string) BotOption {
+	return func(bot *Bot) {
+		bot.userAgent = userAgent
+	}
+}
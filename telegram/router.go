@@ -0,0 +1,216 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+)
+
+// Context is passed to handlers, it's done when handler must stop. Message
+// of callback query's Context is the one with the button, it's nil if the
+// message was sent in inline mode
+type Context struct {
+	context.Context
+	Bot           *Bot
+	Message       *Message
+	CallbackQuery *CallbackQuery
+	// Command is the route's command even if it was matched by alias or
+	// button text, or callback action name
+	Command         string
+	Args            []string
+	callbackPayload []byte
+	conversations   *Conversations
+	// interrupted is set if a conversation was ended by the handled message
+	interrupted bool
+}
+
+// Reply sends text into the chat message came from
+func (ctx *Context) Reply(text string, config *SendMessageConfig) (*Message, error) {
+	return ctx.Bot.SendMessageContext(ctx, ctx.Message.Chat.Id, text, config)
+}
+
+// Decode decodes callback query's data into action of the handled type
+func (ctx *Context) Decode(action CallbackAction) error {
+	return json.Unmarshal(ctx.callbackPayload, action)
+}
+
+// Answer answers callback query, it must be answered even with empty text
+func (ctx *Context) Answer(text string, showAlert bool) (bool, error) {
+	return ctx.Bot.AnswerCallbackQueryContext(ctx, ctx.CallbackQuery.Id, text, showAlert)
+}
+
+func (ctx *Context) chatId() int {
+	if ctx.Message == nil {
+		return 0
+	}
+	return ctx.Message.Chat.Id
+}
+
+type HandlerFunc func(ctx *Context)
+
+// Middleware wraps handler, e.g. to skip it or to do something around it
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// Route is a command like "/add". Message is routed to it if its first word
+// is Command or one of Aliases, or if the whole message is one of
+// ButtonTexts. Routes with Description are listed by Router.Commands
+type Route struct {
+	Command     string
+	Aliases     []string
+	ButtonTexts []string
+	Description string
+	Handler     HandlerFunc
+}
+
+// Router routes messages to handlers of the routes registered with Handle
+type Router struct {
+	routes           []*Route
+	middlewares      []Middleware
+	notFound         HandlerFunc
+	callbacks        map[string]HandlerFunc
+	callbackNotFound HandlerFunc
+	callbackCodec    *CallbackCodec
+}
+
+func NewRouter() *Router {
+	return &Router{
+		callbacks: map[string]HandlerFunc{},
+		callbackNotFound: func(ctx *Context) {
+			ctx.Answer("", false)
+		},
+	}
+}
+
+// Use adds middlewares applied to every handler, the first one added is the
+// outermost
+func (router *Router) Use(middlewares ...Middleware) {
+	router.middlewares = append(router.middlewares, middlewares...)
+}
+
+func (router *Router) Handle(route Route) {
+	router.routes = append(router.routes, &route)
+}
+
+// NotFound sets handler of messages which match no route, they're ignored
+// by default
+func (router *Router) NotFound(handler HandlerFunc) {
+	router.notFound = handler
+}
+
+// SetCallbackCodec sets codec which callback data of buttons is encoded with,
+// without it every callback query is handled as not found
+func (router *Router) SetCallbackCodec(codec *CallbackCodec) {
+	router.callbackCodec = codec
+}
+
+// HandleCallback routes callback queries with action's name to handler
+func (router *Router) HandleCallback(action CallbackAction, handler HandlerFunc) {
+	router.callbacks[action.CallbackName()] = handler
+}
+
+// CallbackNotFound sets handler of callback queries which data can't be
+// decoded or has no handler, they're answered with empty text by default
+func (router *Router) CallbackNotFound(handler HandlerFunc) {
+	router.callbackNotFound = handler
+}
+
+// Commands returns commands of routes with descriptions for SetMyCommands
+func (router *Router) Commands() []BotCommand {
+	commands := []BotCommand{}
+	for _, route := range router.routes {
+		if route.Description == "" {
+			continue
+		}
+		commands = append(commands, BotCommand{
+			Command:     strings.TrimPrefix(route.Command, "/"),
+			Description: route.Description,
+		})
+	}
+	return commands
+}
+
+func (router *Router) match(text string) (*Route, []string) {
+	for _, route := range router.routes {
+		for _, buttonText := range route.ButtonTexts {
+			if text == buttonText {
+				return route, nil
+			}
+		}
+	}
+
+	words := strings.Fields(text)
+	if len(words) == 0 {
+		return nil, nil
+	}
+	// Commands may be addressed to the bot like "/add@spotterbot"
+	name := words[0]
+	if strings.HasPrefix(name, "/") {
+		name = strings.SplitN(name, "@", 2)[0]
+	}
+	for _, route := range router.routes {
+		if route.Command == name {
+			return route, words[1:]
+		}
+		for _, alias := range route.Aliases {
+			if alias == name {
+				return route, words[1:]
+			}
+		}
+	}
+	return nil, words[1:]
+}
+
+// HandleMessage runs handler of the route message matches with middlewares
+func (router *Router) HandleMessage(ctx context.Context, bot *Bot, message *Message) {
+	route, args := router.match(message.Text)
+	handlerCtx := &Context{
+		Context: ctx,
+		Bot:     bot,
+		Message: message,
+		Args:    args,
+	}
+
+	handler := router.notFound
+	if route != nil {
+		handlerCtx.Command = route.Command
+		handler = route.Handler
+	}
+	router.run(handler, handlerCtx)
+}
+
+// HandleCallbackQuery runs handler of the callback query's action with
+// middlewares
+func (router *Router) HandleCallbackQuery(ctx context.Context, bot *Bot, callbackQuery *CallbackQuery) {
+	handlerCtx := &Context{
+		Context:       ctx,
+		Bot:           bot,
+		Message:       callbackQuery.Message,
+		CallbackQuery: callbackQuery,
+	}
+
+	handler := router.callbackNotFound
+	if router.callbackCodec != nil {
+		name, payload, err := router.callbackCodec.decode(callbackQuery.Data)
+		if errors.Is(err, ErrCallbackSignature) {
+			log.Printf("Callback query with invalid signature from chat %d: %q", handlerCtx.chatId(), callbackQuery.Data)
+		}
+		if err == nil && router.callbacks[name] != nil {
+			handlerCtx.Command = name
+			handlerCtx.callbackPayload = payload
+			handler = router.callbacks[name]
+		}
+	}
+	router.run(handler, handlerCtx)
+}
+
+func (router *Router) run(handler HandlerFunc, ctx *Context) {
+	if handler == nil {
+		return
+	}
+	for i := len(router.middlewares) - 1; i >= 0; i-- {
+		handler = router.middlewares[i](handler)
+	}
+	handler(ctx)
+}
@@ -1,28 +1,97 @@
 package telegram
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
-	"log"
 	"net/http"
 	"net/url"
 	"strconv"
-	"strings"
 	"time"
 )
 
-func NewBot(token string) *Bot {
+// NewBot creates bot with default settings changed by options
+func NewBot(token string, options ...BotOption) *Bot {
 	bot := new(Bot)
 	bot.token = token
-	bot.apiEndpoint = "https://api.telegram.org/bot%s/%s"
+	bot.baseUrl = defaultBaseUrl
+	bot.httpClient = http.DefaultClient
+	bot.timeout = defaultTimeout
+	bot.limiter = newRateLimiter(globalMessagesInterval, chatMessagesInterval)
+	for _, option := range options {
+		option(bot)
+	}
 	return bot
 }
 
-func (bot *Bot) call(methodName string, params url.Values) (*Response, error) {
-	url := fmt.Sprintf(bot.apiEndpoint, bot.token, methodName)
+// Requests failed with 429 are retried after time Telegram asks to wait,
+// but no more than maxRetries times
+const maxRetries = 3
+
+func (bot *Bot) call(ctx context.Context, methodName string, params url.Values) (*Response, error) {
+	return bot.callWithFiles(ctx, methodName, params, nil)
+}
+
+// callWithFiles sends files along with params, they're sent as
+// multipart/form-data if at least one of them is uploaded
+func (bot *Bot) callWithFiles(ctx context.Context, methodName string, params url.Values, files map[string]InputFile) (*Response, error) {
+	// Body is encoded once, so uploads from io.Reader can be retried
+	body, contentType, err := encodeRequest(params, files)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only requests addressed to a chat are limited
+	if chatId := params.Get("chat_id"); chatId != "" {
+		err := sleepContext(ctx, bot.limiter.reserve(chatId))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for retry := 0; ; retry++ {
+		telegramResponse, err := bot.doCall(ctx, methodName, params, body, contentType)
+		if err != nil {
+			return nil, err
+		}
+		if telegramResponse.Ok || telegramResponse.ErrorCode != http.StatusTooManyRequests || retry == maxRetries {
+			return telegramResponse, nil
+		}
+		retryAfter := 1
+		if telegramResponse.ResponseParameters != nil && telegramResponse.ResponseParameters.RetryAfter != nil {
+			retryAfter = *telegramResponse.ResponseParameters.RetryAfter
+		}
+		err = sleepContext(ctx, time.Second*time.Duration(retryAfter))
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (bot *Bot) doCall(ctx context.Context, methodName string, params url.Values, body []byte, contentType string) (*Response, error) {
+	url := bot.baseUrl + "/bot" + bot.token + "/" + methodName
+
+	// Long polling getUpdates holds the request for its timeout, so that
+	// time is added to the request timeout
+	timeout := bot.timeout
+	if methodName == "getUpdates" {
+		longPollTimeout, _ := strconv.Atoi(params.Get("timeout"))
+		timeout += time.Second * time.Duration(longPollTimeout)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", contentType)
+	if bot.userAgent != "" {
+		request.Header.Set("User-Agent", bot.userAgent)
+	}
 
-	resp, err := http.Post(url, "application/x-www-form-urlencoded", strings.NewReader(params.Encode()))
+	resp, err := bot.httpClient.Do(request)
 	if err != nil {
 		return nil, err
 	}
@@ -39,17 +108,55 @@ func (bot *Bot) call(methodName string, params url.Values) (*Response, error) {
 	return telegramResponse, nil
 }
 
+// setReplyMarkup sets reply_markup param if replyMarkup has any markup
+func setReplyMarkup(params url.Values, replyMarkup *ReplyMarkup) error {
+	if replyMarkup == nil {
+		return nil
+	}
+	var markup interface{}
+	if replyMarkup.InlineKeyboardMarkup != nil {
+		markup = replyMarkup.InlineKeyboardMarkup
+	} else if replyMarkup.ReplyKeyboardMarkup != nil {
+		markup = replyMarkup.ReplyKeyboardMarkup
+	} else if replyMarkup.ReplyKeyboardRemove != nil {
+		markup = replyMarkup.ReplyKeyboardRemove
+	} else {
+		return nil
+	}
+	jsonReplyMarkup, err := json.Marshal(markup)
+	if err != nil {
+		return err
+	}
+	params.Set("reply_markup", string(jsonReplyMarkup))
+	return nil
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
 func (bot *Bot) GetMe() (*User, error) {
-	telegramResponse, err := bot.call("getMe", url.Values{})
+	return bot.GetMeContext(context.Background())
+}
+
+func (bot *Bot) GetMeContext(ctx context.Context) (*User, error) {
+	telegramResponse, err := bot.call(ctx, "getMe", url.Values{})
 	if err != nil {
 		return nil, err
 	}
 
 	if !telegramResponse.Ok {
-		if telegramResponse.Description != nil {
-			return nil, errors.New(*telegramResponse.Description)
-		}
-		return nil, errors.New("No result in TelegramResponse")
+		return nil, telegramResponse.apiError()
 	}
 
 	user := new(User)
@@ -62,6 +169,10 @@ func (bot *Bot) GetMe() (*User, error) {
 }
 
 func (bot *Bot) SendMessage(chatId int, text string, config *SendMessageConfig) (*Message, error) {
+	return bot.SendMessageContext(context.Background(), chatId, text, config)
+}
+
+func (bot *Bot) SendMessageContext(ctx context.Context, chatId int, text string, config *SendMessageConfig) (*Message, error) {
 	params := url.Values{}
 
 	params.Set("chat_id", strconv.Itoa(chatId))
@@ -82,39 +193,19 @@ func (bot *Bot) SendMessage(chatId int, text string, config *SendMessageConfig)
 		if config.AllowSendingWithoutReply {
 			params.Set("allow_sending_without_reply", "true")
 		}
-		if config.ReplyMarkup != nil {
-			if config.ReplyMarkup.InlineKeyboardMarkup != nil {
-				jsonInlineKeyboardMarkup, err := json.Marshal(config.ReplyMarkup.InlineKeyboardMarkup)
-				if err != nil {
-					return nil, err
-				}
-				params.Set("reply_markup", string(jsonInlineKeyboardMarkup))
-			} else if config.ReplyMarkup.ReplyKeyboardMarkup != nil {
-				jsonReplyKeyboardMarkup, err := json.Marshal(config.ReplyMarkup.ReplyKeyboardMarkup)
-				if err != nil {
-					return nil, err
-				}
-				params.Set("reply_markup", string(jsonReplyKeyboardMarkup))
-			} else if config.ReplyMarkup.ReplyKeyboardRemove != nil {
-				jsonReplyKeyboardRemove, err := json.Marshal(config.ReplyMarkup.ReplyKeyboardRemove)
-				if err != nil {
-					return nil, err
-				}
-				params.Set("reply_markup", string(jsonReplyKeyboardRemove))
-			}
+		err := setReplyMarkup(params, config.ReplyMarkup)
+		if err != nil {
+			return nil, err
 		}
 	}
 
-	telegramResponse, err := bot.call("sendMessage", params)
+	telegramResponse, err := bot.call(ctx, "sendMessage", params)
 	if err != nil {
 		return nil, err
 	}
 
 	if !telegramResponse.Ok {
-		if telegramResponse.Description != nil {
-			return nil, errors.New(*telegramResponse.Description)
-		}
-		return nil, errors.New("No result in TelegramResponse")
+		return nil, telegramResponse.apiError()
 	}
 
 	message := new(Message)
@@ -127,6 +218,10 @@ func (bot *Bot) SendMessage(chatId int, text string, config *SendMessageConfig)
 }
 
 func (bot *Bot) AnswerCallbackQuery(callbackQueryId string, text string, showAlert bool) (bool, error) {
+	return bot.AnswerCallbackQueryContext(context.Background(), callbackQueryId, text, showAlert)
+}
+
+func (bot *Bot) AnswerCallbackQueryContext(ctx context.Context, callbackQueryId string, text string, showAlert bool) (bool, error) {
 	params := url.Values{}
 
 	params.Set("callback_query_id", callbackQueryId)
@@ -137,18 +232,13 @@ func (bot *Bot) AnswerCallbackQuery(callbackQueryId string, text string, showAle
 		params.Set("show_alert", "true")
 	}
 
-	telegramResponse, err := bot.call("answerCallbackQuery", params)
+	telegramResponse, err := bot.call(ctx, "answerCallbackQuery", params)
 	if err != nil {
 		return false, err
 	}
 
 	if !telegramResponse.Ok {
-		log.Println(telegramResponse)
-		if telegramResponse.Description != nil {
-			return false, errors.New(*telegramResponse.Description)
-		} else {
-			return false, errors.New("No result in TelegramResponse")
-		}
+		return false, telegramResponse.apiError()
 	}
 
 	var result bool
@@ -161,6 +251,10 @@ func (bot *Bot) AnswerCallbackQuery(callbackQueryId string, text string, showAle
 }
 
 func (bot *Bot) EditMessageReplyMarkup(chatId int, messageId int, replyMarkup *ReplyMarkup) (bool, error) {
+	return bot.EditMessageReplyMarkupContext(context.Background(), chatId, messageId, replyMarkup)
+}
+
+func (bot *Bot) EditMessageReplyMarkupContext(ctx context.Context, chatId int, messageId int, replyMarkup *ReplyMarkup) (bool, error) {
 	params := url.Values{}
 
 	params.Set("chat_id", strconv.Itoa(chatId))
@@ -173,24 +267,74 @@ func (bot *Bot) EditMessageReplyMarkup(chatId int, messageId int, replyMarkup *R
 		params.Set("reply_markup", string(jsonInlineKeyboardMarkup))
 	}
 
-	telegramResponse, err := bot.call("editMessageReplyMarkup", params)
+	telegramResponse, err := bot.call(ctx, "editMessageReplyMarkup", params)
 	if err != nil {
-		return false, errors.New("No result in TelegramResponse")
+		return false, err
 	}
 
 	if !telegramResponse.Ok {
-		log.Println(telegramResponse)
-		if telegramResponse.Description != nil {
-			return false, errors.New(*telegramResponse.Description)
-		} else {
-			return false, errors.New("No result in TelegramResponse")
-		}
+		return false, telegramResponse.apiError()
+	}
+
+	return true, nil
+}
+
+func (bot *Bot) PinChatMessage(chatId int, messageId int, disableNotification bool) (bool, error) {
+	return bot.PinChatMessageContext(context.Background(), chatId, messageId, disableNotification)
+}
+
+func (bot *Bot) PinChatMessageContext(ctx context.Context, chatId int, messageId int, disableNotification bool) (bool, error) {
+	params := url.Values{}
+
+	params.Set("chat_id", strconv.Itoa(chatId))
+	params.Set("message_id", strconv.Itoa(messageId))
+	if disableNotification {
+		params.Set("disable_notification", "true")
+	}
+
+	telegramResponse, err := bot.call(ctx, "pinChatMessage", params)
+	if err != nil {
+		return false, err
+	}
+
+	if !telegramResponse.Ok {
+		return false, telegramResponse.apiError()
+	}
+
+	return true, nil
+}
+
+// UnpinChatMessage unpins messageId, or the most recent pinned message if
+// messageId is 0
+func (bot *Bot) UnpinChatMessage(chatId int, messageId int) (bool, error) {
+	return bot.UnpinChatMessageContext(context.Background(), chatId, messageId)
+}
+
+func (bot *Bot) UnpinChatMessageContext(ctx context.Context, chatId int, messageId int) (bool, error) {
+	params := url.Values{}
+
+	params.Set("chat_id", strconv.Itoa(chatId))
+	if messageId != 0 {
+		params.Set("message_id", strconv.Itoa(messageId))
+	}
+
+	telegramResponse, err := bot.call(ctx, "unpinChatMessage", params)
+	if err != nil {
+		return false, err
+	}
+
+	if !telegramResponse.Ok {
+		return false, telegramResponse.apiError()
 	}
 
 	return true, nil
 }
 
 func (bot *Bot) GetUpdates(config *GetUpdatesConfig) (*[]Update, error) {
+	return bot.GetUpdatesContext(context.Background(), config)
+}
+
+func (bot *Bot) GetUpdatesContext(ctx context.Context, config *GetUpdatesConfig) (*[]Update, error) {
 	params := url.Values{}
 
 	if config != nil {
@@ -210,21 +354,17 @@ func (bot *Bot) GetUpdates(config *GetUpdatesConfig) (*[]Update, error) {
 		}
 	}
 
-	telegramResponse, err := bot.call("getUpdates", params)
+	telegramResponse, err := bot.call(ctx, "getUpdates", params)
 	if err != nil {
 		return nil, err
 	}
 
 	if !telegramResponse.Ok {
-		if telegramResponse.Description != nil {
-			return nil, errors.New(*telegramResponse.Description)
-		} else {
-			return nil, errors.New("No result in TelegramResponse")
-		}
+		return nil, telegramResponse.apiError()
 	}
 
 	updates := new([]Update)
-	json.Unmarshal(*telegramResponse.Result, &updates)
+	err = json.Unmarshal(*telegramResponse.Result, updates)
 	if err != nil {
 		return nil, err
 	}
@@ -232,24 +372,98 @@ func (bot *Bot) GetUpdates(config *GetUpdatesConfig) (*[]Update, error) {
 	return updates, nil
 }
 
-func (bot *Bot) GrabUpdatesToChan(updatesChannel chan Update) {
+// Failed getUpdates requests are retried with exponential backoff between
+// grabUpdatesMinBackoff and grabUpdatesMaxBackoff, unless Telegram tells how
+// long to wait
+const (
+	grabUpdatesMinBackoff = time.Second
+	grabUpdatesMaxBackoff = time.Minute
+	// pendingUpdatesInterval is how often updates are requested while all
+	// received ones are still being handled
+	pendingUpdatesInterval = time.Second
+)
+
+// GrabUpdatesToChan sends updates to updatesChannel until ctx is done
+func (bot *Bot) GrabUpdatesToChan(ctx context.Context, updatesChannel chan Update, config *GrabUpdatesConfig) {
+	if config == nil {
+		config = &GrabUpdatesConfig{}
+	}
+	tracker := config.Tracker
+	if tracker == nil {
+		tracker = NewUpdatesTracker(0)
+	}
 	getUpdatesConfig := GetUpdatesConfig{
-		Timeout: 30,
+		Timeout:        config.Timeout,
+		AllowedUpdates: config.AllowedUpdates,
 	}
-	for {
-		updates, err := bot.GetUpdates(&getUpdatesConfig)
+	if getUpdatesConfig.Timeout == 0 {
+		getUpdatesConfig.Timeout = 30
+	}
+
+	backoff := grabUpdatesMinBackoff
+	for ctx.Err() == nil {
+		getUpdatesConfig.Offset = tracker.Offset()
+		updates, err := bot.GetUpdatesContext(ctx, &getUpdatesConfig)
 		if err != nil {
-			time.Sleep(time.Second * 5)
+			if ctx.Err() != nil {
+				return
+			}
+			if config.OnError != nil {
+				config.OnError(err)
+			}
+			delay := backoff
+			var apiError *APIError
+			if errors.As(err, &apiError) && apiError.RetryAfter > 0 {
+				delay = time.Duration(apiError.RetryAfter) * time.Second
+			}
+			sleepContext(ctx, delay)
+			backoff *= 2
+			if backoff > grabUpdatesMaxBackoff {
+				backoff = grabUpdatesMaxBackoff
+			}
 			continue
 		}
-		if len(*updates) == 0 {
-			continue
+		backoff = grabUpdatesMinBackoff
+
+		received := false
+		for _, update := range *updates {
+			if !tracker.receive(update.UpdateId) {
+				continue
+			}
+			received = true
+			select {
+			case <-ctx.Done():
+				return
+			case updatesChannel <- update:
+			}
+			if config.Tracker == nil {
+				tracker.Done(update.UpdateId)
+			}
 		}
-		var index int
-		var update Update
-		for index, update = range *updates {
-			updatesChannel <- update
+
+		// Updates which aren't handled yet are returned right away, so
+		// they're requested again only when one of them is handled, or once
+		// in a while to get new ones
+		if !received && len(*updates) > 0 {
+			select {
+			case <-ctx.Done():
+			case <-tracker.changed:
+			case <-time.After(pendingUpdatesInterval):
+			}
 		}
-		getUpdatesConfig.Offset = (*updates)[index].UpdateId + 1
 	}
 }
+
+// ConfirmUpdates marks updates with id lower than offset as handled, so
+// Telegram doesn't send them again
+func (bot *Bot) ConfirmUpdates(offset int) error {
+	return bot.ConfirmUpdatesContext(context.Background(), offset)
+}
+
+func (bot *Bot) ConfirmUpdatesContext(ctx context.Context, offset int) error {
+	_, err := bot.GetUpdatesContext(ctx, &GetUpdatesConfig{
+		Offset: offset,
+		Limit:  1,
+	})
+	return err
+}
@@ -1,10 +1,18 @@
 package telegram
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
 
 type Bot struct {
-	token       string
-	apiEndpoint string
+	token      string
+	baseUrl    string
+	httpClient *http.Client
+	timeout    time.Duration
+	userAgent  string
+	limiter    *rateLimiter
 }
 
 type Response struct {
@@ -12,7 +20,7 @@ type Response struct {
 	Description        *string             `json:"description"`
 	Result             *json.RawMessage    `json:"result"`
 	ErrorCode          int                 `json:"error_code"`
-	ResponseParameters *ResponseParameters `json:"response_parameters"`
+	ResponseParameters *ResponseParameters `json:"parameters"`
 }
 
 type ResponseParameters struct {
@@ -26,6 +34,7 @@ type Update struct {
 	EditedMessage     *Message       `json:"edited_message"`
 	ChannelPost       *Message       `json:"channel_post"`
 	EditedChannelPost *Message       `json:"edited_channel_post"`
+	InlineQuery       *InlineQuery   `json:"inline_query"`
 	CallbackQuery     *CallbackQuery `json:"callback_query"`
 }
 
@@ -52,7 +61,7 @@ type Message struct {
 
 type CallbackQuery struct {
 	Id              string   `json:"id"`
-	From            *User    `json:"form"`
+	From            *User    `json:"from"`
 	Message         *Message `json:"message"`
 	InlineMessageId string   `json:"inline_message_id"`
 	ChatInstance    string   `json:"chat_instance"`
@@ -60,6 +69,14 @@ type CallbackQuery struct {
 	GameShortName   string   `json:"game_short_name"`
 }
 
+type InlineQuery struct {
+	Id       string `json:"id"`
+	From     *User  `json:"from"`
+	Query    string `json:"query"`
+	Offset   string `json:"offset"`
+	ChatType string `json:"chat_type,omitempty"`
+}
+
 type Chat struct {
 	Id            int      `json:"id"`
 	Type          string   `json:"type"`
@@ -83,6 +100,84 @@ type SendMessageConfig struct {
 	ReplyMarkup              *ReplyMarkup
 }
 
+// EditMessageTextConfig is config of EditMessageText, only inline keyboard
+// is allowed in ReplyMarkup
+type EditMessageTextConfig struct {
+	ParseMode             string
+	DisableWebPagePreview bool
+	ReplyMarkup           *ReplyMarkup
+}
+
+type EditMessageCaptionConfig struct {
+	ParseMode   string
+	ReplyMarkup *ReplyMarkup
+}
+
+// SendMediaConfig is config of SendPhoto and SendDocument
+type SendMediaConfig struct {
+	Caption                  string
+	ParseMode                string
+	DisableNotification      bool
+	ReplyToMessageId         int
+	AllowSendingWithoutReply bool
+	ReplyMarkup              *ReplyMarkup
+}
+
+type SendMediaGroupConfig struct {
+	DisableNotification      bool
+	ReplyToMessageId         int
+	AllowSendingWithoutReply bool
+}
+
+const (
+	InputMediaPhoto    = "photo"
+	InputMediaDocument = "document"
+)
+
+type InputMedia struct {
+	Type      string
+	Media     InputFile
+	Caption   string
+	ParseMode string
+}
+
+type inputMediaJson struct {
+	Type      string `json:"type"`
+	Media     string `json:"media"`
+	Caption   string `json:"caption,omitempty"`
+	ParseMode string `json:"parse_mode,omitempty"`
+}
+
+// AnswerInlineQueryConfig is config of AnswerInlineQuery. Results are cached
+// by Telegram for 300 seconds if config is nil, and for CacheTime otherwise
+type AnswerInlineQueryConfig struct {
+	CacheTime  int
+	IsPersonal bool
+	NextOffset string
+}
+
+// InlineQueryResult is one of InlineQueryResult* types
+type InlineQueryResult interface {
+	inlineQueryResult()
+}
+
+type InlineQueryResultArticle struct {
+	Id                  string                  `json:"id"`
+	Title               string                  `json:"title"`
+	InputMessageContent InputTextMessageContent `json:"input_message_content"`
+	ReplyMarkup         *InlineKeyboardMarkup   `json:"reply_markup,omitempty"`
+	Url                 string                  `json:"url,omitempty"`
+	HideUrl             bool                    `json:"hide_url,omitempty"`
+	Description         string                  `json:"description,omitempty"`
+	ThumbUrl            string                  `json:"thumb_url,omitempty"`
+}
+
+type InputTextMessageContent struct {
+	MessageText           string `json:"message_text"`
+	ParseMode             string `json:"parse_mode,omitempty"`
+	DisableWebPagePreview bool   `json:"disable_web_page_preview,omitempty"`
+}
+
 type GetUpdatesConfig struct {
 	Offset         int
 	Limit          int
@@ -90,6 +185,68 @@ type GetUpdatesConfig struct {
 	AllowedUpdates []string
 }
 
+// GrabUpdatesConfig is config of GrabUpdatesToChan. Updates are confirmed as
+// soon as they're sent to the channel if Tracker is nil, and only after
+// they're marked as done in Tracker otherwise. OnError is called on every
+// failed getUpdates request, such requests are retried anyway
+type GrabUpdatesConfig struct {
+	Timeout        int
+	AllowedUpdates []string
+	Tracker        *UpdatesTracker
+	OnError        func(err error)
+}
+
+type SetWebhookConfig struct {
+	Url                string
+	IpAddress          string
+	MaxConnections     int
+	AllowedUpdates     []string
+	DropPendingUpdates bool
+	SecretToken        string
+}
+
+type WebhookInfo struct {
+	Url                          string   `json:"url"`
+	HasCustomCertificate         bool     `json:"has_custom_certificate"`
+	PendingUpdateCount           int      `json:"pending_update_count"`
+	IpAddress                    string   `json:"ip_address,omitempty"`
+	LastErrorDate                int      `json:"last_error_date,omitempty"`
+	LastErrorMessage             string   `json:"last_error_message,omitempty"`
+	LastSynchronizationErrorDate int      `json:"last_synchronization_error_date,omitempty"`
+	MaxConnections               int      `json:"max_connections,omitempty"`
+	AllowedUpdates               []string `json:"allowed_updates,omitempty"`
+}
+
+type BotCommand struct {
+	Command     string `json:"command"`
+	Description string `json:"description"`
+}
+
+// Scopes of bot commands, ChatId is required for chat ones and UserId for
+// BotCommandScopeChatMember
+const (
+	BotCommandScopeDefault               = "default"
+	BotCommandScopeAllPrivateChats       = "all_private_chats"
+	BotCommandScopeAllGroupChats         = "all_group_chats"
+	BotCommandScopeAllChatAdministrators = "all_chat_administrators"
+	BotCommandScopeChat                  = "chat"
+	BotCommandScopeChatAdministrators    = "chat_administrators"
+	BotCommandScopeChatMember            = "chat_member"
+)
+
+type BotCommandScope struct {
+	Type   string `json:"type"`
+	ChatId int    `json:"chat_id,omitempty"`
+	UserId int    `json:"user_id,omitempty"`
+}
+
+// MyCommandsConfig selects commands list by scope and language, default
+// scope and all languages are used if they're not specified
+type MyCommandsConfig struct {
+	Scope        *BotCommandScope
+	LanguageCode string
+}
+
 type ReplyMarkup struct {
 	InlineKeyboardMarkup *InlineKeyboardMarkup
 	ReplyKeyboardMarkup  *ReplyKeyboardMarkup
@@ -0,0 +1,62 @@
+package telegram
+
+import (
+	"sync"
+)
+
+// UpdatesTracker tracks handling of updates received with GrabUpdatesToChan,
+// so getUpdates confirms only handled ones and Offset can be saved to resume
+// from after restart without losing or repeating updates
+type UpdatesTracker struct {
+	pending map[int]bool
+	// next is offset after the last received update
+	next    int
+	changed chan struct{}
+	mutex   sync.Mutex
+}
+
+// NewUpdatesTracker takes offset saved before restart, 0 if there's none
+func NewUpdatesTracker(offset int) *UpdatesTracker {
+	return &UpdatesTracker{
+		pending: map[int]bool{},
+		next:    offset,
+		changed: make(chan struct{}, 1),
+	}
+}
+
+// receive marks update as being handled, it returns false if it already is
+// or was handled
+func (tracker *UpdatesTracker) receive(updateId int) bool {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	if updateId < tracker.next {
+		return false
+	}
+	tracker.pending[updateId] = true
+	tracker.next = updateId + 1
+	return true
+}
+
+// Done marks update as handled
+func (tracker *UpdatesTracker) Done(updateId int) {
+	tracker.mutex.Lock()
+	delete(tracker.pending, updateId)
+	tracker.mutex.Unlock()
+	select {
+	case tracker.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Offset returns id of the first update which isn't handled yet
+func (tracker *UpdatesTracker) Offset() int {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	offset := tracker.next
+	for id := range tracker.pending {
+		if id < offset {
+			offset = id
+		}
+	}
+	return offset
+}
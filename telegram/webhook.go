@@ -0,0 +1,130 @@
+package telegram
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+func (bot *Bot) SetWebhook(config *SetWebhookConfig) (bool, error) {
+	return bot.SetWebhookContext(context.Background(), config)
+}
+
+func (bot *Bot) SetWebhookContext(ctx context.Context, config *SetWebhookConfig) (bool, error) {
+	params := url.Values{}
+
+	params.Set("url", config.Url)
+	if config.IpAddress != "" {
+		params.Set("ip_address", config.IpAddress)
+	}
+	if config.MaxConnections != 0 {
+		params.Set("max_connections", strconv.Itoa(config.MaxConnections))
+	}
+	if config.AllowedUpdates != nil {
+		jsonAllowedUpdates, err := json.Marshal(config.AllowedUpdates)
+		if err != nil {
+			return false, err
+		}
+		params.Set("allowed_updates", string(jsonAllowedUpdates))
+	}
+	if config.DropPendingUpdates {
+		params.Set("drop_pending_updates", "true")
+	}
+	if config.SecretToken != "" {
+		params.Set("secret_token", config.SecretToken)
+	}
+
+	telegramResponse, err := bot.call(ctx, "setWebhook", params)
+	if err != nil {
+		return false, err
+	}
+
+	if !telegramResponse.Ok {
+		return false, telegramResponse.apiError()
+	}
+
+	return true, nil
+}
+
+func (bot *Bot) DeleteWebhook(dropPendingUpdates bool) (bool, error) {
+	return bot.DeleteWebhookContext(context.Background(), dropPendingUpdates)
+}
+
+func (bot *Bot) DeleteWebhookContext(ctx context.Context, dropPendingUpdates bool) (bool, error) {
+	params := url.Values{}
+
+	if dropPendingUpdates {
+		params.Set("drop_pending_updates", "true")
+	}
+
+	telegramResponse, err := bot.call(ctx, "deleteWebhook", params)
+	if err != nil {
+		return false, err
+	}
+
+	if !telegramResponse.Ok {
+		return false, telegramResponse.apiError()
+	}
+
+	return true, nil
+}
+
+func (bot *Bot) GetWebhookInfo() (*WebhookInfo, error) {
+	return bot.GetWebhookInfoContext(context.Background())
+}
+
+func (bot *Bot) GetWebhookInfoContext(ctx context.Context) (*WebhookInfo, error) {
+	telegramResponse, err := bot.call(ctx, "getWebhookInfo", url.Values{})
+	if err != nil {
+		return nil, err
+	}
+
+	if !telegramResponse.Ok {
+		return nil, telegramResponse.apiError()
+	}
+
+	webhookInfo := new(WebhookInfo)
+	err = json.Unmarshal(*telegramResponse.Result, webhookInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	return webhookInfo, nil
+}
+
+// WebhookHandler returns http.Handler which receives updates sent by Telegram
+// to the webhook and pushes them into updatesChannel, like GrabUpdatesToChan
+// does. Requests without X-Telegram-Bot-Api-Secret-Token header equal to
+// secretToken are rejected, so every request is rejected if it's empty
+func WebhookHandler(secretToken string, updatesChannel chan Update) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		requestSecretToken := r.Header.Get("X-Telegram-Bot-Api-Secret-Token")
+		if secretToken == "" || subtle.ConstantTimeCompare([]byte(requestSecretToken), []byte(secretToken)) != 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var update Update
+		decoder := json.NewDecoder(r.Body)
+		err := decoder.Decode(&update)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		select {
+		case updatesChannel <- update:
+			w.WriteHeader(http.StatusOK)
+		case <-r.Context().Done():
+			// Telegram delivers update again if it's not answered with 2XX
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+}
@@ -0,0 +1,44 @@
+package telegram
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWebhookHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		secretToken string
+		// header is X-Telegram-Bot-Api-Secret-Token of the request, it isn't
+		// sent if it's empty
+		header     string
+		wantStatus int
+	}{
+		{"secret token", "secret", "secret", http.StatusOK},
+		{"wrong secret token", "secret", "forged", http.StatusUnauthorized},
+		{"missing secret token", "secret", "", http.StatusUnauthorized},
+		{"empty secret token", "", "", http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			updatesChannel := make(chan Update, 1)
+			request := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(`{"update_id":1}`))
+			if test.header != "" {
+				request.Header.Set("X-Telegram-Bot-Api-Secret-Token", test.header)
+			}
+			recorder := httptest.NewRecorder()
+
+			WebhookHandler(test.secretToken, updatesChannel).ServeHTTP(recorder, request)
+
+			if recorder.Code != test.wantStatus {
+				t.Errorf("status = %d, want %d", recorder.Code, test.wantStatus)
+			}
+			if delivered := len(updatesChannel) == 1; delivered != (test.wantStatus == http.StatusOK) {
+				t.Errorf("update delivered = %v, want %v", delivered, !delivered)
+			}
+		})
+	}
+}
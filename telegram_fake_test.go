@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"./telegram"
+)
+
+type fakeTelegramRequest struct {
+	method string
+	params url.Values
+}
+
+// fakeTelegram is Bot API server which answers every method with success and
+// remembers requests, so what the bot sent can be checked
+type fakeTelegram struct {
+	*httptest.Server
+	requests      []fakeTelegramRequest
+	lastMessageId int
+	mutex         sync.Mutex
+}
+
+func newFakeTelegram(t *testing.T) *fakeTelegram {
+	fake := new(fakeTelegram)
+	fake.Server = httptest.NewServer(http.HandlerFunc(fake.serve))
+	t.Cleanup(fake.Close)
+	return fake
+}
+
+func (fake *fakeTelegram) serve(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	method := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+
+	fake.mutex.Lock()
+	fake.requests = append(fake.requests, fakeTelegramRequest{method, r.PostForm})
+	fake.lastMessageId++
+	messageId := fake.lastMessageId
+	fake.mutex.Unlock()
+
+	// Methods sending or editing messages return the message, others true
+	result := "true"
+	if strings.HasPrefix(method, "send") || strings.HasPrefix(method, "edit") {
+		chatId, _ := strconv.Atoi(r.PostForm.Get("chat_id"))
+		result = fmt.Sprintf(`{"message_id": %d, "date": 0, "chat": {"id": %d, "type": "private"}}`, messageId, chatId)
+	}
+	fmt.Fprintf(w, `{"ok": true, "result": %s}`, result)
+}
+
+func (fake *fakeTelegram) bot() *telegram.Bot {
+	return telegram.NewBot("token", telegram.WithBaseUrl(fake.URL))
+}
+
+// Requests returns params of requests to method
+func (fake *fakeTelegram) Requests(method string) []url.Values {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	requests := []url.Values{}
+	for _, request := range fake.requests {
+		if request.method == method {
+			requests = append(requests, request.params)
+		}
+	}
+	return requests
+}
+
+// Texts returns texts of messages sent into chatId
+func (fake *fakeTelegram) Texts(chatId int) []string {
+	texts := []string{}
+	for _, params := range fake.Requests("sendMessage") {
+		if params.Get("chat_id") == strconv.Itoa(chatId) {
+			texts = append(texts, params.Get("text"))
+		}
+	}
+	return texts
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Config file is parsed with a small TOML subset parser: [sections],
+// key = value pairs, comments, strings, integers, booleans and arrays of them.
+// That's all the config needs and saves a dependency
+
+type tomlValue struct {
+	value interface{}
+	line  int
+}
+
+// parseToml returns values by their full key like "section.key"
+func parseToml(data string) (map[string]tomlValue, error) {
+	values := map[string]tomlValue{}
+	section := ""
+	lines := strings.Split(data, "\n")
+	for i := 0; i < len(lines); i++ {
+		lineNumber := i + 1
+		line := strings.TrimSpace(stripTomlComment(lines[i]))
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") || strings.HasPrefix(line, "[[") {
+				return nil, fmt.Errorf("line %d: invalid section header %q", lineNumber, line)
+			}
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if section == "" {
+				return nil, fmt.Errorf("line %d: empty section name", lineNumber)
+			}
+			continue
+		}
+
+		separatorIndex := strings.Index(line, "=")
+		if separatorIndex == -1 {
+			return nil, fmt.Errorf("line %d: expected key = value", lineNumber)
+		}
+		key := strings.TrimSpace(line[:separatorIndex])
+		rawValue := strings.TrimSpace(line[separatorIndex+1:])
+		if key == "" {
+			return nil, fmt.Errorf("line %d: empty key", lineNumber)
+		}
+		// Arrays may span several lines
+		for strings.HasPrefix(rawValue, "[") && !tomlBracketsBalanced(rawValue) && i+1 < len(lines) {
+			i++
+			rawValue += " " + strings.TrimSpace(stripTomlComment(lines[i]))
+		}
+
+		value, err := parseTomlValue(rawValue)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", lineNumber, err.Error())
+		}
+
+		fullKey := key
+		if section != "" {
+			fullKey = section + "." + key
+		}
+		if _, ok := values[fullKey]; ok {
+			return nil, fmt.Errorf("line %d: duplicate key %q", lineNumber, fullKey)
+		}
+		values[fullKey] = tomlValue{value, lineNumber}
+	}
+	return values, nil
+}
+
+func stripTomlComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch {
+		case quote != 0 && line[i] == '\\' && quote == '"':
+			i++
+		case quote != 0 && line[i] == quote:
+			quote = 0
+		case quote == 0 && (line[i] == '"' || line[i] == '\''):
+			quote = line[i]
+		case quote == 0 && line[i] == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func tomlBracketsBalanced(value string) bool {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(value); i++ {
+		switch {
+		case quote != 0 && value[i] == '\\' && quote == '"':
+			i++
+		case quote != 0 && value[i] == quote:
+			quote = 0
+		case quote == 0 && (value[i] == '"' || value[i] == '\''):
+			quote = value[i]
+		case quote == 0 && value[i] == '[':
+			depth++
+		case quote == 0 && value[i] == ']':
+			depth--
+		}
+	}
+	return depth == 0
+}
+
+func parseTomlValue(rawValue string) (interface{}, error) {
+	value, rest, err := parseTomlValuePrefix(rawValue)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(rest) != "" {
+		return nil, fmt.Errorf("unexpected %q after value", strings.TrimSpace(rest))
+	}
+	return value, nil
+}
+
+// parseTomlValuePrefix parses value at the start of rawValue and returns
+// what's left after it
+func parseTomlValuePrefix(rawValue string) (interface{}, string, error) {
+	rawValue = strings.TrimLeft(rawValue, " \t")
+	if rawValue == "" {
+		return nil, "", fmt.Errorf("missing value")
+	}
+
+	switch rawValue[0] {
+	case '"':
+		for i := 1; i < len(rawValue); i++ {
+			if rawValue[i] == '\\' {
+				i++
+				continue
+			}
+			if rawValue[i] == '"' {
+				value, err := strconv.Unquote(rawValue[:i+1])
+				if err != nil {
+					return nil, "", fmt.Errorf("invalid string %s", rawValue[:i+1])
+				}
+				return value, rawValue[i+1:], nil
+			}
+		}
+		return nil, "", fmt.Errorf("unterminated string")
+	case '\'':
+		end := strings.IndexByte(rawValue[1:], '\'')
+		if end == -1 {
+			return nil, "", fmt.Errorf("unterminated string")
+		}
+		return rawValue[1 : end+1], rawValue[end+2:], nil
+	case '[':
+		values := []interface{}{}
+		rest := strings.TrimLeft(rawValue[1:], " \t")
+		for {
+			if strings.HasPrefix(rest, "]") {
+				return values, rest[1:], nil
+			}
+			value, afterValue, err := parseTomlValuePrefix(rest)
+			if err != nil {
+				return nil, "", err
+			}
+			values = append(values, value)
+			rest = strings.TrimLeft(afterValue, " \t")
+			if strings.HasPrefix(rest, ",") {
+				rest = strings.TrimLeft(rest[1:], " \t")
+			} else if !strings.HasPrefix(rest, "]") {
+				return nil, "", fmt.Errorf("expected , or ] in array")
+			}
+		}
+	}
+
+	end := strings.IndexAny(rawValue, " \t,]")
+	if end == -1 {
+		end = len(rawValue)
+	}
+	token := rawValue[:end]
+	switch token {
+	case "true":
+		return true, rawValue[end:], nil
+	case "false":
+		return false, rawValue[end:], nil
+	}
+	number, err := strconv.ParseInt(strings.ReplaceAll(token, "_", ""), 10, 64)
+	if err != nil {
+		return nil, "", fmt.Errorf("unsupported value %q", token)
+	}
+	return int(number), rawValue[end:], nil
+}
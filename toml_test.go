@@ -0,0 +1,126 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseToml(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want map[string]tomlValue
+		// wantErr is a substring of the error, values aren't checked if it's set
+		wantErr string
+	}{
+		{
+			name: "sections and comments",
+			data: "# comment\nowners = [1] # trailing\n\n[vk]\ntoken = 'abc'\n[telegram]\ntoken = \"def\"",
+			want: map[string]tomlValue{
+				"owners":         {[]interface{}{1}, 2},
+				"vk.token":       {"abc", 5},
+				"telegram.token": {"def", 7},
+			},
+		},
+		{
+			name: "quoted #",
+			data: "a = \"x # y\" # comment\nb = 'x # y'\nc = \"\\\"#\\\"\"",
+			want: map[string]tomlValue{
+				"a": {"x # y", 1},
+				"b": {"x # y", 2},
+				"c": {`"#"`, 3},
+			},
+		},
+		{
+			name: "multi-line array",
+			data: "owners = [\n  1, # first\n  2_000,\n]\nnext = true",
+			want: map[string]tomlValue{
+				"owners": {[]interface{}{1, 2000}, 1},
+				"next":   {true, 5},
+			},
+		},
+		{
+			name: "multi-line array with ] in string",
+			data: "values = [\n  \"]\",\n  'x',\n]",
+			want: map[string]tomlValue{
+				"values": {[]interface{}{"]", "x"}, 1},
+			},
+		},
+		{
+			name: "nested array",
+			data: "values = [[1, 2], [false]]",
+			want: map[string]tomlValue{
+				"values": {[]interface{}{[]interface{}{1, 2}, []interface{}{false}}, 1},
+			},
+		},
+		{
+			name:    "duplicate key",
+			data:    "[vk]\ntoken = \"a\"\n[telegram]\ntoken = \"b\"\n[vk]\ntoken = \"c\"",
+			wantErr: `line 6: duplicate key "vk.token"`,
+		},
+		{
+			name:    "unsupported value",
+			data:    "poll_interval = 7s",
+			wantErr: `line 1: unsupported value "7s"`,
+		},
+		{
+			name:    "float",
+			data:    "\nvalue = 1.5",
+			wantErr: `line 2: unsupported value "1.5"`,
+		},
+		{
+			name:    "unterminated string",
+			data:    "token = \"abc",
+			wantErr: "line 1: unterminated string",
+		},
+		{
+			name:    "value after value",
+			data:    "token = \"a\" \"b\"",
+			wantErr: `line 1: unexpected "\"b\"" after value`,
+		},
+		{
+			name:    "unterminated array",
+			data:    "owners = [1,\n2",
+			wantErr: "line 1: expected , or ] in array",
+		},
+		{
+			name:    "missing value",
+			data:    "token =",
+			wantErr: "line 1: missing value",
+		},
+		{
+			name:    "missing =",
+			data:    "token",
+			wantErr: "line 1: expected key = value",
+		},
+		{
+			name:    "array of tables",
+			data:    "[[owners]]",
+			wantErr: "line 1: invalid section header",
+		},
+		{
+			name:    "empty section",
+			data:    "[ ]",
+			wantErr: "line 1: empty section name",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			values, err := parseToml(test.data)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("parseToml() error = %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseToml() error = %v", err)
+			}
+			if !reflect.DeepEqual(values, test.want) {
+				t.Errorf("parseToml() = %v, want %v", values, test.want)
+			}
+		})
+	}
+}
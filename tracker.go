@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"./telegram"
+)
+
+var targetsMutex sync.Mutex
+
+type presence struct {
+	Id           int
+	Online       bool
+	Platform     int
+	LastSeenTime int
+}
+
+// Offline friends aren't reported by friends.getOnline, so every
+// friendsFullSyncTicks tick they're requested with users.get too not to miss
+// short sessions between ticks
+const friendsFullSyncTicks = 10
+
+// Tracker watches targets' presence with VK and notifies owner when they
+// appear online
+type Tracker struct {
+	targets   *Targets
+	vk        VKClient
+	friends   *Friends
+	longPoll  *LongPoll
+	dashboard *Dashboard
+	clock     Clock
+}
+
+// NewTracker takes optional dashboard, which is nil if there's none
+func NewTracker(targets *Targets, vk VKClient, clock Clock, dashboard *Dashboard) *Tracker {
+	friends := NewFriends(vk, clock)
+	return &Tracker{
+		targets:   targets,
+		vk:        vk,
+		friends:   friends,
+		longPoll:  NewLongPoll(vk, friends, clock),
+		dashboard: dashboard,
+		clock:     clock,
+	}
+}
+
+// startTracing polls presence until ctx is done. Notifications are sent with
+// notifyCtx, so targets removed from tracing list on shutdown are still
+// notified about before startTracing returns
+func (tracker *Tracker) startTracing(ctx context.Context, notifyCtx context.Context, bot *telegram.Bot) {
+	longPollDone := make(chan struct{})
+	defer func() {
+		<-longPollDone
+	}()
+	go func() {
+		defer close(longPollDone)
+		tracker.longPoll.start(ctx, func(p presence) {
+			tracker.observe(notifyCtx, bot, p)
+		})
+	}()
+	for tick := 1; ; tick++ {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tracker.clock.After(getConfig().PollInterval):
+		}
+		startTime := time.Now()
+		err := tracker.tick(ctx, notifyCtx, bot, tick)
+		if ctx.Err() != nil {
+			return
+		}
+		metrics.observeTick(time.Since(startTime), err)
+		if err != nil {
+			log.Println(err.Error())
+		}
+	}
+}
+
+// tick makes one poll of targets which aren't covered by Long Poll, or of all
+// targets on full sync
+func (tracker *Tracker) tick(ctx context.Context, notifyCtx context.Context, bot *telegram.Bot, tick int) error {
+	targetsMutex.Lock()
+	targets := make(Targets, len(*tracker.targets))
+	copy(targets, *tracker.targets)
+	targetsMutex.Unlock()
+	tracker.friends.refreshIfStale(ctx)
+	fullSync := tick%friendsFullSyncTicks == 0
+
+	userIdsToGet := []string{}
+	friendTargetsCount := 0
+	for _, target := range targets {
+		// Long Poll misses events when its history is outdated or while it
+		// reconnects, so full sync covers its friends too
+		if tracker.longPoll.covers(target.Id) && !fullSync {
+			continue
+		}
+		if tracker.friends.contains(target.Id) {
+			friendTargetsCount++
+			if !fullSync {
+				continue
+			}
+		}
+		userIdsToGet = append(userIdsToGet, strconv.Itoa(target.Id))
+	}
+
+	var onlineFriendsErr error
+	if friendTargetsCount > 0 && !fullSync {
+		onlineFriends, err := tracker.vk.GetOnlineFriends(ctx)
+		if err != nil {
+			onlineFriendsErr = err
+		} else {
+			for _, ids := range [][]int{onlineFriends.Online, onlineFriends.OnlineMobile} {
+				for _, id := range ids {
+					tracker.observe(notifyCtx, bot, presence{Id: id, Online: true})
+				}
+			}
+		}
+	}
+
+	if len(userIdsToGet) == 0 {
+		return onlineFriendsErr
+	}
+	users, err := tracker.vk.GetUsers(ctx, userIdsToGet)
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		tracker.observe(notifyCtx, bot, presence{
+			Id:           user.Id,
+			Online:       user.Online == 1,
+			Platform:     user.LastSeen.Platfrom,
+			LastSeenTime: user.LastSeen.Time,
+		})
+	}
+	return onlineFriendsErr
+}
+
+// observe notifies owner if target appeared online since it was added
+func (tracker *Tracker) observe(ctx context.Context, bot *telegram.Bot, p presence) {
+	targets := tracker.targets
+	targetsMutex.Lock()
+	target := targets.find(p.Id)
+	if target == nil {
+		targetsMutex.Unlock()
+		return
+	}
+	if !p.Online && p.LastSeenTime == target.LastSeenTime {
+		targetsMutex.Unlock()
+		tracker.dashboard.observe(p)
+		return
+	}
+
+	// Notification is prepared before target is removed, so it's not removed
+	// without owners being notified
+	config := getConfig()
+	notificationMessageText := tracker.notificationText(config, target, p)
+	repeatData, err := callbackCodec.Encode(repeatAction{Id: target.Id, DomainIsPrimary: target.DomainIsPrimary})
+	if err != nil {
+		targetsMutex.Unlock()
+		log.Println(err.Error())
+		return
+	}
+	targets.remove(target.Id)
+	targetsMutex.Unlock()
+	tracker.dashboard.spot(target, p)
+
+	sendMessageConfig := &telegram.SendMessageConfig{
+		ParseMode:             telegram.ParseModeHTML,
+		DisableWebPagePreview: true,
+		DisableNotification:   config.QuietHours.contains(tracker.clock.Now()),
+		ReplyMarkup: notificationKeyboard(target, telegram.InlineKeyboardButton{
+			Text: "🔄 Repeat", CallbackData: repeatData,
+		}),
+	}
+	for _, owner := range config.Owners {
+		_, err := bot.SendMessageContext(ctx, owner, notificationMessageText, sendMessageConfig)
+		metrics.observeNotification(err)
+		if telegram.IsBlockedByUser(err) || telegram.IsChatNotFound(err) {
+			log.Printf("Owner %d can't be notified until they start the bot: %s", owner, err.Error())
+		} else if err != nil {
+			log.Println(err.Error())
+		}
+	}
+}
+
+// notificationText renders online template from config, or the default one
+// if it fails, e.g. because of invalid template in config file
+func (tracker *Tracker) notificationText(config *Config, target *Target, p presence) string {
+	data := target.templateData()
+	if p.LastSeenTime != 0 {
+		data.LastSeen = formatLastSeen(time.Unix(int64(p.LastSeenTime), 0), tracker.clock.Now())
+	}
+	data.Platform = vkPlatforms[p.Platform]
+
+	text := new(strings.Builder)
+	err := config.Templates.Online.Execute(text, data)
+	if err == nil {
+		return text.String()
+	}
+	log.Println("Can't execute online template, default one is used:", err.Error())
+	text.Reset()
+	err = defaultTemplates.Online.Execute(text, data)
+	if err != nil {
+		log.Println(err.Error())
+	}
+	return text.String()
+}
+
+// formatLastSeen formats time as clock time, with date if it's not today
+func formatLastSeen(lastSeen time.Time, now time.Time) string {
+	if lastSeen.Format("2006-01-02") == now.Format("2006-01-02") {
+		return lastSeen.Format("15:04")
+	}
+	return lastSeen.Format("2 Jan 15:04")
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+)
+
+func TestTrackerTick(t *testing.T) {
+	const lastSeenTime = 1600000000
+
+	tests := []struct {
+		name string
+		// change scripts VK after target 1 was added
+		change func(vk *FakeVKClient)
+		friend bool
+		// coveredByLongPoll makes friend covered by healthy Long Poll
+		coveredByLongPoll bool
+		tick              int
+		wantTargets       []int
+		wantNotified      bool
+		// wantLastSeen and wantPlatform are checked in notification if
+		// wantLastSeen isn't 0
+		wantLastSeen int
+		wantPlatform string
+	}{
+		{
+			name:        "still offline",
+			change:      func(vk *FakeVKClient) {},
+			tick:        1,
+			wantTargets: []int{1},
+		},
+		{
+			name: "online",
+			change: func(vk *FakeVKClient) {
+				vk.SetOnline(1, 4)
+			},
+			tick:         1,
+			wantTargets:  []int{},
+			wantNotified: true,
+			wantLastSeen: lastSeenTime,
+			wantPlatform: "📱 Android",
+		},
+		{
+			name: "was online between ticks",
+			change: func(vk *FakeVKClient) {
+				vk.SetOffline(1, lastSeenTime+60)
+			},
+			tick:         1,
+			wantTargets:  []int{},
+			wantNotified: true,
+			wantLastSeen: lastSeenTime + 60,
+		},
+		{
+			name: "friend online",
+			change: func(vk *FakeVKClient) {
+				vk.SetOnline(1, 4)
+			},
+			friend:       true,
+			tick:         1,
+			wantTargets:  []int{},
+			wantNotified: true,
+		},
+		{
+			name: "friend was online between ticks",
+			change: func(vk *FakeVKClient) {
+				vk.SetOffline(1, lastSeenTime+60)
+			},
+			friend:      true,
+			tick:        1,
+			wantTargets: []int{1},
+		},
+		{
+			name: "friend was online between ticks on full sync",
+			change: func(vk *FakeVKClient) {
+				vk.SetOffline(1, lastSeenTime+60)
+			},
+			friend:       true,
+			tick:         friendsFullSyncTicks,
+			wantTargets:  []int{},
+			wantNotified: true,
+		},
+		{
+			name: "friend covered by Long Poll",
+			change: func(vk *FakeVKClient) {
+				vk.SetOnline(1, 4)
+			},
+			friend:            true,
+			coveredByLongPoll: true,
+			tick:              1,
+			wantTargets:       []int{1},
+		},
+		{
+			name: "friend covered by Long Poll was online on full sync",
+			change: func(vk *FakeVKClient) {
+				vk.SetOffline(1, lastSeenTime+60)
+			},
+			friend:            true,
+			coveredByLongPoll: true,
+			tick:              friendsFullSyncTicks,
+			wantTargets:       []int{},
+			wantNotified:      true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useTestGlobals(t, testOwner)
+			fakeTelegram := newFakeTelegram(t)
+			vk := NewFakeVKClient()
+			vk.AddUser(1, "durov", "Pavel", "Durov", lastSeenTime)
+			vk.SetFriend(1, test.friend)
+			tracedTargets := Targets{{Id: 1, Domain: "durov", DomainIsPrimary: true, FirstName: "Pavel", LastName: "Durov", LastSeenTime: lastSeenTime}}
+			clock := NewFakeClock(time.Unix(lastSeenTime, 0))
+			tracker := NewTracker(&tracedTargets, vk, clock, nil)
+			tracker.longPoll.setHealthy(test.coveredByLongPoll)
+
+			test.change(vk)
+			err := tracker.tick(context.Background(), context.Background(), fakeTelegram.bot(), test.tick)
+			if err != nil {
+				t.Fatalf("tick() error = %v", err)
+			}
+
+			if ids := targetIds(&tracedTargets); !reflect.DeepEqual(ids, test.wantTargets) {
+				t.Errorf("targets = %v, want %v", ids, test.wantTargets)
+			}
+			texts := fakeTelegram.Texts(testOwner)
+			if notified := len(texts) == 1; notified != test.wantNotified {
+				t.Fatalf("notifications = %q, want notified %v", texts, test.wantNotified)
+			}
+			if test.wantNotified && test.wantLastSeen != 0 {
+				wantText := `✉️ <a href="https://vk.com/durov">durov</a> (Pavel Durov) Online` +
+					"\n🕒 Last seen " + time.Unix(int64(test.wantLastSeen), 0).Format("15:04")
+				if test.wantPlatform != "" {
+					wantText += "\n" + test.wantPlatform
+				}
+				if texts[0] != wantText {
+					t.Errorf("notification = %q, want %q", texts[0], wantText)
+				}
+			}
+		})
+	}
+}
+
+func TestTrackerObserve(t *testing.T) {
+	useTestGlobals(t, testOwner, testOwner+1)
+	fakeTelegram := newFakeTelegram(t)
+	tracedTargets := Targets{{Id: 1, Domain: "durov", DomainIsPrimary: true, FirstName: "Pavel", LastName: "Durov", LastSeenTime: 1600000000}}
+	tracker := NewTracker(&tracedTargets, NewFakeVKClient(), NewFakeClock(time.Unix(1600000000, 0)), nil)
+	bot := fakeTelegram.bot()
+
+	// Presence of users which aren't traced is ignored
+	tracker.observe(context.Background(), bot, presence{Id: 2, Online: true})
+	if requests := fakeTelegram.Requests("sendMessage"); len(requests) != 0 {
+		t.Fatalf("%d messages sent about not traced user, want none", len(requests))
+	}
+
+	tracker.observe(context.Background(), bot, presence{Id: 1, Online: true, Platform: 7, LastSeenTime: 1600000000})
+	tracker.observe(context.Background(), bot, presence{Id: 1, Online: true, Platform: 7, LastSeenTime: 1600000000})
+
+	// Every owner is notified once, with profile link and Repeat buttons
+	for _, owner := range []int{testOwner, testOwner + 1} {
+		if texts := fakeTelegram.Texts(owner); len(texts) != 1 {
+			t.Errorf("owner %d got %d notifications, want 1", owner, len(texts))
+		}
+	}
+	requests := fakeTelegram.Requests("sendMessage")
+	if parseMode := requests[0].Get("parse_mode"); parseMode != "HTML" {
+		t.Errorf("parse_mode = %q, want HTML", parseMode)
+	}
+	replyMarkup := requests[0].Get("reply_markup")
+	for _, want := range []string{`"url":"https://vk.com/durov"`, `"callback_data":"2:`} {
+		if !strings.Contains(replyMarkup, want) {
+			t.Errorf("reply_markup = %s, want it to contain %s", replyMarkup, want)
+		}
+	}
+	if ids := targetIds(&tracedTargets); len(ids) != 0 {
+		t.Errorf("targets = %v, want none", ids)
+	}
+}
+
+func TestTrackerObserveBrokenTemplate(t *testing.T) {
+	useTestGlobals(t, testOwner)
+	getConfig().Templates.Online = template.Must(template.New("online").Parse("{{.Missing}}"))
+	fakeTelegram := newFakeTelegram(t)
+	tracedTargets := Targets{{Id: 1, Domain: "durov", DomainIsPrimary: true, FirstName: "Pavel", LastName: "Durov"}}
+	tracker := NewTracker(&tracedTargets, NewFakeVKClient(), NewFakeClock(time.Unix(1600000000, 0)), nil)
+
+	tracker.observe(context.Background(), fakeTelegram.bot(), presence{Id: 1, Online: true})
+
+	// Owner is notified with the default template
+	wantTexts := []string{`✉️ <a href="https://vk.com/durov">durov</a> (Pavel Durov) Online`}
+	if texts := fakeTelegram.Texts(testOwner); !reflect.DeepEqual(texts, wantTexts) {
+		t.Errorf("notifications = %q, want %q", texts, wantTexts)
+	}
+	if ids := targetIds(&tracedTargets); len(ids) != 0 {
+		t.Errorf("targets = %v, want none", ids)
+	}
+}
+
+// TestTrackingFlow runs add → offline → online → notify on FakeClock, so
+// poll intervals pass instantly
+func TestTrackingFlow(t *testing.T) {
+	useTestGlobals(t, testOwner)
+	fakeTelegram := newFakeTelegram(t)
+	vk := NewFakeVKClient()
+	vk.AddUser(1, "durov", "Pavel", "Durov", 1600000000)
+	clock := NewFakeClock(time.Unix(1600000000, 0))
+	pollInterval := getConfig().PollInterval
+
+	router := NewSpotter(vk, nil).router()
+	router.HandleMessage(context.Background(), fakeTelegram.bot(), testMessage(testOwner, "/add durov"))
+	if ids := targetIds(&targets); !reflect.DeepEqual(ids, []int{1}) {
+		t.Fatalf("targets after /add = %v, want [1]", ids)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	tracing := make(chan struct{})
+	go func() {
+		defer close(tracing)
+		// Bot of its own isn't rate limited by reply to /add
+		NewTracker(&targets, vk, clock, nil).startTracing(ctx, context.Background(), fakeTelegram.bot())
+	}()
+	defer func() {
+		cancel()
+		<-tracing
+	}()
+
+	// Tracker waits for the next tick and Long Poll, which fake VK doesn't
+	// support, waits to retry
+	const waiters = 2
+	clock.BlockUntilWaiters(waiters)
+
+	clock.Advance(pollInterval)
+	clock.BlockUntilWaiters(waiters)
+	if texts := fakeTelegram.Texts(testOwner); len(texts) != 1 {
+		t.Fatalf("messages after offline tick = %q, want only reply to /add", texts)
+	}
+	if ids := targetIds(&targets); !reflect.DeepEqual(ids, []int{1}) {
+		t.Fatalf("targets after offline tick = %v, want [1]", ids)
+	}
+
+	vk.SetOnline(1, 2)
+	clock.Advance(pollInterval)
+	clock.BlockUntilWaiters(waiters)
+	texts := fakeTelegram.Texts(testOwner)
+	wantText := `✉️ <a href="https://vk.com/durov">durov</a> (Pavel Durov) Online` +
+		"\n🕒 Last seen " + time.Unix(1600000000, 0).Format("15:04") + "\n📱 iPhone"
+	if len(texts) != 2 || texts[1] != wantText {
+		t.Fatalf("messages after online tick = %q, want notification %q", texts, wantText)
+	}
+	if ids := targetIds(&targets); len(ids) != 0 {
+		t.Errorf("targets after online tick = %v, want none", ids)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type vkError struct {
+	Code    int    `json:"error_code"`
+	Message string `json:"error_msg"`
+}
+
+func (err *vkError) Error() string {
+	return fmt.Sprintf("vk error %d: %s", err.Code, err.Message)
+}
+
+// VKClient is everything the bot needs from VK API
+type VKClient interface {
+	GetUsers(ctx context.Context, userIds []string) ([]vkUser, error)
+	GetFriends(ctx context.Context) ([]int, error)
+	GetOnlineFriends(ctx context.Context) (*vkOnlineFriends, error)
+	GetLongPollServer(ctx context.Context) (*vkLongPollServer, error)
+}
+
+// vkRequestTimeout limits every VK API request, so one stalled request
+// doesn't freeze polling
+const vkRequestTimeout = time.Second * 30
+
+type vkApiClient struct {
+	token      string
+	apiUrl     string
+	version    string
+	lang       string
+	httpClient *http.Client
+}
+
+func NewVKClient(token, apiUrl, version, lang string) VKClient {
+	return &vkApiClient{
+		token:      token,
+		apiUrl:     apiUrl,
+		version:    version,
+		lang:       lang,
+		httpClient: &http.Client{Timeout: vkRequestTimeout},
+	}
+}
+
+func (vk *vkApiClient) call(ctx context.Context, methodName string, params url.Values, result interface{}) error {
+	err := vk.doCall(ctx, methodName, params, result)
+	if err != nil && ctx.Err() == nil {
+		metrics.observeVKError(methodName, err)
+	}
+	return err
+}
+
+func (vk *vkApiClient) doCall(ctx context.Context, methodName string, params url.Values, result interface{}) error {
+	params.Set("access_token", vk.token)
+	params.Set("v", vk.version)
+	params.Set("lang", vk.lang)
+
+	url := strings.TrimSuffix(vk.apiUrl, "/") + "/" + methodName + "?" + params.Encode()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	response, err := vk.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	var responseStruct struct {
+		Response *json.RawMessage `json:"response"`
+		Error    *vkError         `json:"error"`
+	}
+
+	decoder := json.NewDecoder(response.Body)
+	err = decoder.Decode(&responseStruct)
+	if err != nil {
+		return err
+	}
+
+	if responseStruct.Error != nil {
+		return responseStruct.Error
+	}
+	if responseStruct.Response == nil {
+		return fmt.Errorf("%s method error", methodName)
+	}
+
+	return json.Unmarshal(*responseStruct.Response, result)
+}
+
+type vkUser struct {
+	Id              int    `json:"id"`
+	FirstName       string `json:"first_name"`
+	LastName        string `json:"last_name"`
+	IsClosed        bool   `json:"is_closed"`
+	CanAccessClosed bool   `json:"can_access_closed"`
+	Domain          string `json:"domain"`
+	Online          int    `json:"online"`
+	LastSeen        struct {
+		Platfrom int `json:"platform"`
+		Time     int `json:"time"`
+	} `json:"last_seen"`
+}
+
+func (vk *vkApiClient) GetUsers(ctx context.Context, userIds []string) ([]vkUser, error) {
+	params := url.Values{}
+	params.Set("user_ids", strings.Join(userIds, ","))
+	params.Set("fields", "last_seen,online,domain")
+
+	var users []vkUser
+	err := vk.call(ctx, "users.get", params, &users)
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func (vk *vkApiClient) GetFriends(ctx context.Context) ([]int, error) {
+	var friends vkFriends
+	err := vk.call(ctx, "friends.get", url.Values{}, &friends)
+	if err != nil {
+		return nil, err
+	}
+	return friends.Items, nil
+}
+
+type vkOnlineFriends struct {
+	Online       []int `json:"online"`
+	OnlineMobile []int `json:"online_mobile"`
+}
+
+func (vk *vkApiClient) GetOnlineFriends(ctx context.Context) (*vkOnlineFriends, error) {
+	params := url.Values{}
+	params.Set("online_mobile", "1")
+
+	onlineFriends := new(vkOnlineFriends)
+	err := vk.call(ctx, "friends.getOnline", params, onlineFriends)
+	if err != nil {
+		return nil, err
+	}
+	return onlineFriends, nil
+}
+
+type vkLongPollServer struct {
+	Key    string `json:"key"`
+	Server string `json:"server"`
+	Ts     vkInt  `json:"ts"`
+}
+
+func (vk *vkApiClient) GetLongPollServer(ctx context.Context) (*vkLongPollServer, error) {
+	params := url.Values{}
+	params.Set("lp_version", strconv.Itoa(longPollVersion))
+
+	server := new(vkLongPollServer)
+	err := vk.call(ctx, "messages.getLongPollServer", params, server)
+	if err != nil {
+		return nil, err
+	}
+	return server, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// Response shapes differ between VK API versions, since API version is
+// configurable types below accept every shape the bot may meet
+
+// Versions below 5.0 return "uid" instead of "id"
+func (user *vkUser) UnmarshalJSON(data []byte) error {
+	type plainUser vkUser
+	var compatUser struct {
+		plainUser
+		Uid int `json:"uid"`
+	}
+	err := json.Unmarshal(data, &compatUser)
+	if err != nil {
+		return err
+	}
+	*user = vkUser(compatUser.plainUser)
+	if user.Id == 0 {
+		user.Id = compatUser.Uid
+	}
+	return nil
+}
+
+// Versions below 5.0 return friends.get as plain array of ids instead of
+// {"count", "items"} object
+type vkFriends struct {
+	Count int   `json:"count"`
+	Items []int `json:"items"`
+}
+
+func (friends *vkFriends) UnmarshalJSON(data []byte) error {
+	var ids []int
+	if json.Unmarshal(data, &ids) == nil {
+		friends.Count = len(ids)
+		friends.Items = ids
+		return nil
+	}
+	type plainFriends vkFriends
+	return json.Unmarshal(data, (*plainFriends)(friends))
+}
+
+// friends.getOnline returns plain array of ids if online_mobile isn't
+// supported, which is the case in versions below 5.0
+func (onlineFriends *vkOnlineFriends) UnmarshalJSON(data []byte) error {
+	var ids []int
+	if json.Unmarshal(data, &ids) == nil {
+		onlineFriends.Online = ids
+		onlineFriends.OnlineMobile = nil
+		return nil
+	}
+	type plainOnlineFriends vkOnlineFriends
+	return json.Unmarshal(data, (*plainOnlineFriends)(onlineFriends))
+}
+
+// vkInt is a number which some versions send as a string, like Long Poll's ts
+type vkInt int
+
+func (number *vkInt) UnmarshalJSON(data []byte) error {
+	var value int
+	if json.Unmarshal(data, &value) == nil {
+		*number = vkInt(value)
+		return nil
+	}
+	var stringValue string
+	err := json.Unmarshal(data, &stringValue)
+	if err != nil {
+		return err
+	}
+	value, err = strconv.Atoi(stringValue)
+	if err != nil {
+		return err
+	}
+	*number = vkInt(value)
+	return nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// FakeVKClient is in-memory VKClient which users can be scripted to go
+// online and offline, so tracking logic can run without VK
+type FakeVKClient struct {
+	users          map[int]*vkUser
+	friends        map[int]bool
+	longPollServer *vkLongPollServer
+	// longPollServerCalls is how many times GetLongPollServer was called
+	longPollServerCalls int
+	// Err is returned by every method if set
+	Err   error
+	mutex sync.Mutex
+}
+
+func NewFakeVKClient() *FakeVKClient {
+	return &FakeVKClient{
+		users:   map[int]*vkUser{},
+		friends: map[int]bool{},
+	}
+}
+
+func (fake *FakeVKClient) AddUser(id int, domain, firstName, lastName string, lastSeenTime int) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	user := &vkUser{
+		Id:        id,
+		Domain:    domain,
+		FirstName: firstName,
+		LastName:  lastName,
+	}
+	user.LastSeen.Time = lastSeenTime
+	fake.users[id] = user
+}
+
+func (fake *FakeVKClient) SetFriend(id int, isFriend bool) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	fake.friends[id] = isFriend
+}
+
+func (fake *FakeVKClient) SetOnline(id int, platform int) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	if user, ok := fake.users[id]; ok {
+		user.Online = 1
+		user.LastSeen.Platfrom = platform
+	}
+}
+
+func (fake *FakeVKClient) SetOffline(id int, lastSeenTime int) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	if user, ok := fake.users[id]; ok {
+		user.Online = 0
+		user.LastSeen.Time = lastSeenTime
+	}
+}
+
+// GetUsers resolves both ids and domains like users.get does, domains are
+// case-insensitive and unknown ones are skipped
+func (fake *FakeVKClient) GetUsers(ctx context.Context, userIds []string) ([]vkUser, error) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	if fake.Err != nil {
+		return nil, fake.Err
+	}
+	users := []vkUser{}
+	for _, idOrDomain := range userIds {
+		for _, user := range fake.users {
+			if strings.EqualFold(user.Domain, idOrDomain) || strconv.Itoa(user.Id) == idOrDomain {
+				users = append(users, *user)
+				break
+			}
+		}
+	}
+	return users, nil
+}
+
+func (fake *FakeVKClient) GetFriends(ctx context.Context) ([]int, error) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	if fake.Err != nil {
+		return nil, fake.Err
+	}
+	ids := []int{}
+	for id, isFriend := range fake.friends {
+		if isFriend {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+func (fake *FakeVKClient) GetOnlineFriends(ctx context.Context) (*vkOnlineFriends, error) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	if fake.Err != nil {
+		return nil, fake.Err
+	}
+	onlineFriends := new(vkOnlineFriends)
+	for id, isFriend := range fake.friends {
+		if user, ok := fake.users[id]; ok && isFriend && user.Online == 1 {
+			onlineFriends.Online = append(onlineFriends.Online, id)
+		}
+	}
+	sort.Ints(onlineFriends.Online)
+	return onlineFriends, nil
+}
+
+// SetLongPollServer makes GetLongPollServer return server, which is usually
+// httptest.Server faking Long Poll
+func (fake *FakeVKClient) SetLongPollServer(server *vkLongPollServer) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	fake.longPollServer = server
+}
+
+func (fake *FakeVKClient) LongPollServerCalls() int {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	return fake.longPollServerCalls
+}
+
+// GetLongPollServer fails unless server is set with SetLongPollServer, so
+// friends are tracked by polling
+func (fake *FakeVKClient) GetLongPollServer(ctx context.Context) (*vkLongPollServer, error) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	fake.longPollServerCalls++
+	if fake.Err != nil {
+		return nil, fake.Err
+	}
+	if fake.longPollServer == nil {
+		return nil, errors.New("long poll server isn't set in fake vk client")
+	}
+	server := *fake.longPollServer
+	return &server, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+
+	"./telegram"
+)
+
+// serveWebhook registers webhook with Telegram and pushes updates it
+// receives into updates until ctx is done
+func serveWebhook(ctx context.Context, bot *telegram.Bot, config *Config, updates chan telegram.Update) error {
+	webhookUrl, err := url.Parse(config.WebhookUrl)
+	if err != nil {
+		return err
+	}
+	path := webhookUrl.Path
+	if path == "" {
+		path = "/"
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(path, telegram.WebhookHandler(config.WebhookSecret, updates))
+	server := &http.Server{
+		Addr:    config.WebhookAddr,
+		Handler: mux,
+		// Requests waiting for updates to be read are cancelled on shutdown
+		BaseContext: func(net.Listener) context.Context {
+			return ctx
+		},
+	}
+
+	_, err = bot.SetWebhookContext(ctx, &telegram.SetWebhookConfig{
+		Url:            config.WebhookUrl,
+		AllowedUpdates: allowedUpdates,
+		SecretToken:    config.WebhookSecret,
+	})
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		<-ctx.Done()
+		server.Shutdown(context.Background())
+	}()
+	err = server.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}